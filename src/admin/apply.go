@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/gossip"
+	"atsuko-nexus/src/lifecycle"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/p2p"
+	"atsuko-nexus/src/version"
+)
+
+// Metadata keys holding the state admin commands leave behind.
+const (
+	metaTasksPaused = "tasks_paused"
+	metaMinVersion  = "min_version"
+)
+
+// TasksPaused reports whether an admin has paused task processing.
+func TasksPaused() bool {
+	return db.GetMeta(metaTasksPaused) == "true"
+}
+
+// MinVersion returns the minimum network version last announced by an admin, or "".
+func MinVersion() string {
+	return db.GetMeta(metaMinVersion)
+}
+
+// Service subscribes to admin commands on the default gossip node and prunes expired nonces.
+// onOutdated is called when an admin announces a minimum version newer than this build.
+func Service(onOutdated func()) lifecycle.Service {
+	return lifecycle.Func("admin commands", func(ctx context.Context) error {
+		node := gossip.Default()
+		if node == nil {
+			return errors.New("gossip layer not started")
+		}
+		// Check signatures before the gossip layer forwards anything, so a forged or expired
+		// command stops at the first node instead of flooding the network.
+		node.Validate(Topic, func(msg gossip.Message) error {
+			var c Command
+			if err := json.Unmarshal(msg.Payload, &c); err != nil {
+				return err
+			}
+			return c.Verify()
+		})
+		gossip.On(node, Topic, func(from string, c Command) {
+			handle(from, c, onOutdated)
+		})
+		// Replay the trust log in case identity.trusted_keys changed, then offer it to peers
+		if _, err := trustLogDefault().rebuildLocked(); err != nil {
+			return err
+		}
+		p2p.SetTrustLog(trustLogDefault())
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					pruneNonces()
+				}
+			}
+		}()
+		checkMinVersion(MinVersion(), onOutdated)
+		return nil
+	}, nil)
+}
+
+// handle verifies and applies one command.
+func handle(from string, c Command, onOutdated func()) {
+	if err := accept(c); err != nil {
+		if errors.Is(err, errReplay) {
+			logger.Log("DEBUG", "admin", fmt.Sprintf("Ignoring replayed %s command (nonce %s)", c.Kind, c.Nonce))
+			return
+		}
+		logger.Log("ERROR", "admin", fmt.Sprintf("Rejected %s command relayed by %s: %v", c.Kind, from, err))
+		return
+	}
+	logger.Log("INFO", "admin", fmt.Sprintf("Applying admin %s command (nonce %s)", c.Kind, c.Nonce))
+	if err := apply(c, onOutdated); err != nil {
+		logger.Log("ERROR", "admin", fmt.Sprintf("Failed to apply %s command: %v", c.Kind, err))
+	}
+}
+
+// apply carries out a verified command.
+func apply(c Command, onOutdated func()) error {
+	switch c.Kind {
+	case KindBan:
+		if c.Target == "" {
+			return errors.New("ban without target")
+		}
+		reason := "admin ban"
+		if c.Reason != "" {
+			reason = "admin ban: " + c.Reason
+		}
+		p2p.Bans().BanNode(c.Target, reason, time.Duration(c.Duration)*time.Second)
+		p2p.Peers().Remove(c.Target)
+		return nil
+
+	case KindPurge:
+		p2p.PurgePeers()
+		return nil
+
+	case KindMinVersion:
+		if _, err := semver.NewVersion(strings.TrimPrefix(c.Version, "v")); err != nil {
+			return fmt.Errorf("invalid version %q", c.Version)
+		}
+		if err := db.SetMeta(metaMinVersion, c.Version); err != nil {
+			return err
+		}
+		checkMinVersion(c.Version, onOutdated)
+		return nil
+
+	case KindPauseTasks:
+		state := "false"
+		if c.Paused {
+			state = "true"
+		}
+		if err := db.SetMeta(metaTasksPaused, state); err != nil {
+			return err
+		}
+		logger.Log("INFO", "admin", "Task processing paused: "+state)
+		return nil
+
+	case KindTrustAdd, KindTrustRevoke, KindTrustRotate:
+		return trustLogDefault().add(c)
+
+	default:
+		return fmt.Errorf("unknown command kind %q", c.Kind)
+	}
+}
+
+// checkMinVersion calls onOutdated if this build is older than min.
+func checkMinVersion(min string, onOutdated func()) {
+	if min == "" {
+		return
+	}
+	want, err1 := semver.NewVersion(strings.TrimPrefix(min, "v"))
+	have, err2 := semver.NewVersion(strings.TrimPrefix(version.Current, "v"))
+	if err1 != nil || err2 != nil || !have.LessThan(want) {
+		return
+	}
+	logger.Log("ERROR", "admin", fmt.Sprintf("This node runs %s but the network requires %s or newer", version.Current, min))
+	if onOutdated != nil {
+		onOutdated()
+	}
+}
@@ -0,0 +1,165 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"atsuko-nexus/src/gossip"
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/p2p"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
+)
+
+const usage = `usage: atsuko admin [flags] <command>
+
+commands:
+  ban <node-id>          ban a node network-wide (--duration, --reason)
+  purge                  make every node drop its peer cache
+  min-version <version>  announce the minimum allowed version
+  pause-tasks            pause task processing on every node
+  resume-tasks           resume task processing
+  trust-add <key>        trust a public key (--role admin|operator)
+  trust-revoke <key>     revoke a trusted public key
+  trust-rotate <old> <new>
+                         replace a trusted key, keeping its role
+
+The command is signed with identity.admin_key and handed to the local node
+(and any --peer), which gossips it to the rest of the network. trust-revoke and
+trust-rotate keep only those trust commands of the removed key that the local
+node holds; anything else it signed stops counting.`
+
+// RunCLI signs the command described by args and pushes it to the running local node and any
+// --peer addresses. It requires identity.admin_key to hold a trusted private key whose role may
+// issue the command.
+func RunCLI(args []string) error {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	var peers multiFlag
+	fs.Var(&peers, "peer", "also deliver to this IP:PORT (repeatable)")
+	ttl := fs.Duration("ttl", time.Hour, "how long the command stays valid")
+	duration := fs.Duration("duration", 0, "ban length (0 = permanent)")
+	reason := fs.String("reason", "", "reason recorded with a ban")
+	role := fs.String("role", types.RoleOperator, "role granted by trust-add")
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), usage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cmd, err := parseCommand(fs.Args())
+	if err != nil {
+		fs.Usage()
+		return err
+	}
+	cmd.Reason = *reason
+	cmd.Duration = int(duration.Seconds())
+	if cmd.Kind == KindTrustAdd {
+		cmd.Role = *role
+	}
+	port, _ := settings.Get("network.listen_port").(int)
+	local := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	if removed := cmd.removedKey(); removed != "" {
+		// Anything the removed key signed that is not listed here stops counting, so list what
+		// the local node holds.
+		if cmd.Covers, err = coveredBy(local, removed); err != nil {
+			return fmt.Errorf("reading the trust log from %s: %w", local, err)
+		}
+	}
+
+	key, keyRole, ok := types.SigningKey()
+	if !ok {
+		return errors.New("identity.admin_key does not hold a trusted key")
+	}
+	if !mayIssue(keyRole, cmd.Kind) {
+		return fmt.Errorf("a %s key cannot issue %s", keyRole, cmd.Kind)
+	}
+	if err := cmd.Sign(key, *ttl); err != nil {
+		return err
+	}
+
+	addrs := append([]string{local}, peers...)
+	node := gossip.New(nodeid.GetNodeID(), p2p.AddressTransport(addrs), gossip.Config{
+		Fanout:   len(addrs),
+		TTL:      gossip.ConfigFromSettings().TTL,
+		CacheTTL: time.Minute,
+	})
+	msg, err := node.Publish(Topic, cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Published %s command %s (nonce %s) to %s\n", cmd.Kind, msg.ID, cmd.Nonce, strings.Join(addrs, ", "))
+	return nil
+}
+
+// parseCommand turns positional arguments into an unsigned Command.
+func parseCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return Command{}, errors.New("missing command")
+	}
+	switch args[0] {
+	case "ban":
+		if len(args) != 2 {
+			return Command{}, errors.New("ban needs a node ID")
+		}
+		return Command{Kind: KindBan, Target: args[1]}, nil
+	case "purge":
+		return Command{Kind: KindPurge}, nil
+	case "min-version":
+		if len(args) != 2 {
+			return Command{}, errors.New("min-version needs a version")
+		}
+		return Command{Kind: KindMinVersion, Version: args[1]}, nil
+	case "pause-tasks":
+		return Command{Kind: KindPauseTasks, Paused: true}, nil
+	case "resume-tasks":
+		return Command{Kind: KindPauseTasks, Paused: false}, nil
+	case "trust-add":
+		if len(args) != 2 {
+			return Command{}, errors.New("trust-add needs a public key")
+		}
+		return Command{Kind: KindTrustAdd, Key: args[1]}, nil
+	case "trust-revoke":
+		if len(args) != 2 {
+			return Command{}, errors.New("trust-revoke needs a public key")
+		}
+		return Command{Kind: KindTrustRevoke, Key: args[1]}, nil
+	case "trust-rotate":
+		if len(args) != 3 {
+			return Command{}, errors.New("trust-rotate needs the old and the new public key")
+		}
+		return Command{Kind: KindTrustRotate, Target: args[1], Key: args[2]}, nil
+	default:
+		return Command{}, fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// coveredBy returns the nonces of the trust commands signed by keyHex in the log held at addr.
+func coveredBy(addr, keyHex string) ([]string, error) {
+	raw, err := p2p.FetchTrustLog(addr)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, r := range raw {
+		var c Command
+		if json.Unmarshal(r, &c) == nil && c.Signer == keyHex {
+			out = append(out, c.Nonce)
+		}
+	}
+	return out, nil
+}
+
+// multiFlag collects a repeatable string flag.
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
+
+func (m *multiFlag) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
@@ -0,0 +1,176 @@
+// Package admin carries signed control commands from admin nodes to the whole network.
+// An admin signs a Command with the key in identity.admin_key and publishes it over gossip; every node
+// verifies the signature against the trust set, rejects replays by nonce and expiry, and applies it.
+package admin
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/types"
+)
+
+// Topic is the gossip topic admin commands travel on.
+const Topic = "admin.command"
+
+// Command kinds.
+const (
+	KindBan        = "ban"         // ban Target for Duration seconds (0 = permanent)
+	KindPurge      = "purge"       // drop the peer cache and re-learn the network
+	KindMinVersion = "min_version" // announce the oldest version allowed on the network
+	KindPauseTasks = "pause_tasks" // pause (Paused=true) or resume task processing
+
+	KindTrustAdd    = "trust_add"    // trust Key with Role
+	KindTrustRevoke = "trust_revoke" // revoke Key
+	KindTrustRotate = "trust_rotate" // replace Target key with Key, keeping its role
+)
+
+const (
+	// maxLifetime is the longest validity window a command may carry.
+	maxLifetime = 24 * time.Hour
+	// clockSkew is how far in the future Issued may lie before a command is refused.
+	clockSkew = 5 * time.Minute
+)
+
+var (
+	errBadSignature = errors.New("bad signature")
+	errNotAdmin     = errors.New("signer is not trusted for this command")
+	errExpired      = errors.New("command expired")
+	errReplay       = errors.New("nonce already used")
+)
+
+// Command is a signed instruction from an admin node.
+type Command struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Role     string `json:"role,omitempty"`
+	// Covers lists, on a trust revoke or rotation, the nonces of the removed key's trust commands
+	// that still stand; every other command that key signed is void.
+	Covers []string `json:"covers,omitempty"`
+
+	Nonce     string `json:"nonce"`
+	Issued    string `json:"issued"`
+	Expires   string `json:"expires"`
+	Signer    string `json:"signer"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// signingBytes is the canonical encoding that is signed: the command without its signature.
+func (c Command) signingBytes() []byte {
+	c.Signature = ""
+	data, _ := json.Marshal(c)
+	return data
+}
+
+// Sign stamps c with a fresh nonce and a validity window of ttl, and signs it with key.
+func (c *Command) Sign(key ed25519.PrivateKey, ttl time.Duration) error {
+	if ttl <= 0 || ttl > maxLifetime {
+		return fmt.Errorf("ttl must be between 0 and %s", maxLifetime)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	c.Nonce = hex.EncodeToString(nonce)
+	c.Issued = now.Format(time.RFC3339)
+	c.Expires = now.Add(ttl).Format(time.RFC3339)
+	c.Signer = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	c.Signature = hex.EncodeToString(ed25519.Sign(key, c.signingBytes()))
+	return nil
+}
+
+// Verify checks that c is signed by a key whose trust role may issue it and is inside its validity window.
+// It does not consult the nonce store; see accept.
+func (c Command) Verify() error {
+	pub, err := hex.DecodeString(c.Signer)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errBadSignature
+	}
+	if !mayIssue(types.TrustedRole(pub), c.Kind) {
+		return errNotAdmin
+	}
+	if err := c.verifySignature(); err != nil {
+		return err
+	}
+
+	issued, err1 := time.Parse(time.RFC3339, c.Issued)
+	expires, err2 := time.Parse(time.RFC3339, c.Expires)
+	if err1 != nil || err2 != nil || c.Nonce == "" {
+		return errExpired
+	}
+	now := time.Now()
+	if issued.After(now.Add(clockSkew)) || !now.Before(expires) || expires.Sub(issued) > maxLifetime {
+		return errExpired
+	}
+	return nil
+}
+
+// verifySignature checks that c was signed by the key named in Signer, whoever that is.
+func (c Command) verifySignature() error {
+	pub, err := hex.DecodeString(c.Signer)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errBadSignature
+	}
+	sig, err := hex.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), c.signingBytes(), sig) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// mayIssue reports whether a key with the given trust role may sign a command of kind.
+// Operators handle day-to-day moderation; everything else, including trust changes, needs an admin.
+func mayIssue(role, kind string) bool {
+	switch role {
+	case types.RoleAdmin:
+		return true
+	case types.RoleOperator:
+		return kind == KindBan || kind == KindPauseTasks
+	default:
+		return false
+	}
+}
+
+// accept verifies c and records its nonce, refusing any nonce seen before. Nonces are kept
+// until the command expires; after that Verify rejects the command anyway.
+func accept(c Command) error {
+	if err := c.Verify(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.BucketNonces))
+		if b.Get([]byte(c.Nonce)) != nil {
+			return errReplay
+		}
+		return b.Put([]byte(c.Nonce), []byte(c.Expires))
+	})
+}
+
+// pruneNonces forgets nonces whose commands have expired.
+func pruneNonces() {
+	now := time.Now()
+	var stale []string
+	_ = db.ForEach(db.BucketNonces, func(key string, value []byte) error {
+		if t, err := time.Parse(time.RFC3339, string(value)); err != nil || now.After(t) {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	for _, key := range stale {
+		_ = db.Delete(db.BucketNonces, key)
+	}
+}
@@ -0,0 +1,323 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/p2p"
+	"atsuko-nexus/src/types"
+)
+
+// metaTrustLog holds the signed trust commands in the database metadata bucket.
+const metaTrustLog = "trust_log"
+
+var (
+	errVoided       = errors.New("signer was revoked without this command being covered")
+	errTrustLogFull = errors.New("trust log is full")
+)
+
+// trustLog keeps every trust command this node has verified, signatures included, so the trust
+// set can be rebuilt from them and handed to peers that missed a change. Commands are replayed
+// oldest first (by Issued, then nonce) from the roots, and each one only counts if its signer
+// held a role that may issue it at that point.
+//
+// Issued is chosen by the signer, so it cannot be what protects against a leaked key. Instead a
+// revoke or rotation lists in Covers the commands of the removed key its issuer had seen, and every
+// other command that key signed is void wherever it is dated. A grant backdated by a revoked key is
+// therefore ignored by every node, whether it arrives before or after the revocation, and nodes
+// holding the same commands and roots arrive at the same trust set whatever order they arrived in.
+type trustLog struct {
+	mu      sync.Mutex
+	entries map[string]Command // by nonce
+}
+
+var (
+	tlog     *trustLog
+	tlogOnce sync.Once
+)
+
+// trustLogDefault returns the process-wide trust log, loading it from the database on first use.
+func trustLogDefault() *trustLog {
+	tlogOnce.Do(func() {
+		tlog = &trustLog{entries: make(map[string]Command)}
+		raw := db.GetMeta(metaTrustLog)
+		if raw == "" {
+			return
+		}
+		var cmds []Command
+		if err := json.Unmarshal([]byte(raw), &cmds); err != nil {
+			logger.Log("ERROR", "admin", "Stored trust log unreadable: "+err.Error())
+			return
+		}
+		for _, c := range cmds {
+			tlog.entries[c.Nonce] = c
+		}
+	})
+	return tlog
+}
+
+// isTrustKind reports whether kind changes the trust set.
+func isTrustKind(kind string) bool {
+	return kind == KindTrustAdd || kind == KindTrustRevoke || kind == KindTrustRotate
+}
+
+// removedKey returns the key a revoke or rotation takes out of the trust set, or "".
+func (c Command) removedKey() string {
+	switch c.Kind {
+	case KindTrustRevoke:
+		return strings.ToLower(c.Key)
+	case KindTrustRotate:
+		return strings.ToLower(c.Target)
+	}
+	return ""
+}
+
+// add records a verified trust command and rebuilds the trust set. It fails if the command
+// does not take effect, for example a revoke of the last admin key, or the log is full.
+func (l *trustLog) add(c Command) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.entries[c.Nonce]; !ok && len(l.entries) >= p2p.MaxTrustEntries {
+		return errTrustLogFull
+	}
+	l.entries[c.Nonce] = c
+	errs, err := l.rebuild()
+	if err != nil {
+		return err
+	}
+	return errs[c.Nonce]
+}
+
+// Hash identifies the set of commands held.
+func (l *trustLog) Hash() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := sha256.New()
+	for _, c := range l.sorted() {
+		fmt.Fprintf(h, "%s:%s\n", c.Nonce, c.Signature)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Entries returns every command held, encoded.
+func (l *trustLog) Entries() []json.RawMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []json.RawMessage
+	for _, c := range l.sorted() {
+		if data, err := json.Marshal(c); err == nil {
+			out = append(out, data)
+		}
+	}
+	return out
+}
+
+// Merge adds commands received from a peer. Each must be a correctly signed trust command issued
+// no later than now, by a key that holds a role allowed to issue it at some point of the replay
+// and that no revocation has voided it for. Anything else is dropped rather than stored, so keys
+// outside the trust set cannot fill the log; the log never grows past p2p.MaxTrustEntries.
+func (l *trustLog) Merge(raw []json.RawMessage) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var fresh []string
+	for _, r := range raw {
+		var c Command
+		if err := json.Unmarshal(r, &c); err != nil || !isTrustKind(c.Kind) {
+			continue
+		}
+		if _, ok := l.entries[c.Nonce]; ok {
+			continue
+		}
+		if err := c.verifySignature(); err != nil {
+			logger.Log("WARN", "admin", fmt.Sprintf("Dropping trust log entry %s: %v", c.Nonce, err))
+			continue
+		}
+		issued, err := time.Parse(time.RFC3339, c.Issued)
+		if err != nil || issued.After(time.Now().Add(clockSkew)) {
+			logger.Log("WARN", "admin", fmt.Sprintf("Dropping trust log entry %s: bad issue time %q", c.Nonce, c.Issued))
+			continue
+		}
+		l.entries[c.Nonce] = c
+		fresh = append(fresh, c.Nonce)
+	}
+	if len(fresh) == 0 {
+		return 0
+	}
+
+	// Keep only what the replay can ever honour, then trim to the cap by dropping the newest of
+	// the new entries, so nothing already held is pushed out.
+	r := replayTrust(types.TrustSet{}, l.sorted())
+	kept := make(map[string]bool, len(fresh))
+	for _, nonce := range fresh {
+		c := l.entries[nonce]
+		if r.void[nonce] || !r.everMayIssue(c.Signer, c.Kind) {
+			logger.Log("WARN", "admin", fmt.Sprintf("Dropping trust log entry %s: %s may not issue %s", nonce, c.Signer, c.Kind))
+			delete(l.entries, nonce)
+			continue
+		}
+		kept[nonce] = true
+	}
+	cmds := l.sorted()
+	for i := len(cmds) - 1; i >= 0 && len(l.entries) > p2p.MaxTrustEntries; i-- {
+		if kept[cmds[i].Nonce] {
+			logger.Log("WARN", "admin", fmt.Sprintf("Dropping trust log entry %s: log is full", cmds[i].Nonce))
+			delete(l.entries, cmds[i].Nonce)
+			delete(kept, cmds[i].Nonce)
+		}
+	}
+	if len(kept) > 0 {
+		if _, err := l.rebuild(); err != nil {
+			logger.Log("ERROR", "admin", "Failed to rebuild trust set: "+err.Error())
+		}
+	}
+	return len(kept)
+}
+
+// rebuild replays the log into a trust set, persists both and makes the set active. Commands that
+// can never take effect, because they were voided or their signer never held a role that may
+// issue them, are pruned, so logs holding the same live commands hash alike. It returns why each
+// command that did not take effect was skipped. Callers must hold mu.
+func (l *trustLog) rebuild() (map[string]error, error) {
+	r := replayTrust(types.TrustSet{}, l.sorted())
+	for nonce, c := range l.entries {
+		if r.void[nonce] || !r.everMayIssue(c.Signer, c.Kind) {
+			delete(l.entries, nonce)
+		}
+	}
+	data, err := json.Marshal(l.sorted())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.SetMeta(metaTrustLog, string(data)); err != nil {
+		return nil, err
+	}
+	return r.skipped, types.SetTrust(r.set)
+}
+
+// sorted returns the commands oldest first. Callers must hold mu.
+func (l *trustLog) sorted() []Command {
+	out := make([]Command, 0, len(l.entries))
+	for _, c := range l.entries {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, out[i].Issued)
+		tj, _ := time.Parse(time.RFC3339, out[j].Issued)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return out[i].Nonce < out[j].Nonce
+	})
+	return out
+}
+
+// trustReplay is the outcome of replaying a trust log.
+type trustReplay struct {
+	set     types.TrustSet
+	skipped map[string]error    // why each command that did not take effect was skipped, by nonce
+	void    map[string]bool     // commands voided by a revocation of their signer, by nonce
+	held    map[string][]string // every role each key held at some point of the replay
+}
+
+// everMayIssue reports whether signer held a role allowed to issue kind at any point.
+func (r trustReplay) everMayIssue(signer, kind string) bool {
+	for _, role := range r.held[signer] {
+		if mayIssue(role, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayTrust applies cmds, oldest first, to base on top of the roots; base is the empty
+// TrustSet except in tests. A revoke or rotation of a key voids every command signed by that key
+// which is not in its Covers, and voiding a command can undo a revocation that command made, so
+// the replay repeats until no further command is voided. The void set only grows, so it ends.
+func replayTrust(base types.TrustSet, cmds []Command) trustReplay {
+	void := make(map[string]bool)
+	for {
+		r := replayOnce(base, cmds, void)
+		grown := false
+		for _, rc := range cmds {
+			removed := rc.removedKey()
+			if removed == "" || r.skipped[rc.Nonce] != nil || void[rc.Nonce] {
+				continue
+			}
+			covered := make(map[string]bool, len(rc.Covers))
+			for _, n := range rc.Covers {
+				covered[n] = true
+			}
+			for _, c := range cmds {
+				if c.Signer == removed && c.Nonce != rc.Nonce && !covered[c.Nonce] && !void[c.Nonce] {
+					void[c.Nonce] = true
+					grown = true
+				}
+			}
+		}
+		if !grown {
+			r.void = void
+			return r
+		}
+	}
+}
+
+// replayOnce applies every command not in void, in order.
+func replayOnce(base types.TrustSet, cmds []Command, void map[string]bool) trustReplay {
+	ts := base
+	ts.Keys = append([]types.TrustedKey(nil), base.Keys...)
+	ts.Revoked = append([]string(nil), base.Revoked...)
+	r := trustReplay{skipped: make(map[string]error), held: make(map[string][]string)}
+	note := func() {
+		for _, k := range ts.Active().Keys {
+			if !slices.Contains(r.held[k.Key], k.Role) {
+				r.held[k.Key] = append(r.held[k.Key], k.Role)
+			}
+		}
+	}
+	note()
+	for _, c := range cmds {
+		if void[c.Nonce] {
+			r.skipped[c.Nonce] = errVoided
+			continue
+		}
+		if !mayIssue(ts.RoleOf(c.Signer), c.Kind) {
+			r.skipped[c.Nonce] = errNotAdmin
+			continue
+		}
+		var err error
+		switch c.Kind {
+		case KindTrustAdd:
+			err = ts.Grant(c.Key, c.Role, c.Issued)
+		case KindTrustRevoke:
+			err = ts.Revoke(c.Key)
+		case KindTrustRotate:
+			err = ts.Rotate(c.Target, c.Key, c.Issued)
+		}
+		if err != nil {
+			r.skipped[c.Nonce] = err
+			continue
+		}
+		ts.Version++
+		ts.Updated = c.Issued
+		note()
+	}
+	r.set = ts
+	return r
+}
+
+// rebuildLocked is rebuild for callers that do not hold mu.
+func (l *trustLog) rebuildLocked() (map[string]error, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rebuild()
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"atsuko-nexus/src/types"
+)
+
+// testKey is a keypair with its public half in hex, as trust commands name keys.
+type testKey struct {
+	priv ed25519.PrivateKey
+	hex  string
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{priv: priv, hex: hex.EncodeToString(pub)}
+}
+
+// signedAt signs c with k as if issued at the given minute, which the signer is free to choose.
+func signedAt(t *testing.T, k testKey, c Command, minute int) Command {
+	t.Helper()
+	issued := time.Date(2026, 1, 1, 0, minute, 0, 0, time.UTC)
+	c.Nonce = fmt.Sprintf("%s-%02d-%s", c.Kind, minute, k.hex[:8])
+	c.Issued = issued.Format(time.RFC3339)
+	c.Expires = issued.Add(time.Hour).Format(time.RFC3339)
+	c.Signer = k.hex
+	c.Signature = hex.EncodeToString(ed25519.Sign(k.priv, c.signingBytes()))
+	if err := c.verifySignature(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// replayAll replays cmds in log order, however they were received.
+func replayAll(base types.TrustSet, cmds ...Command) trustReplay {
+	l := &trustLog{entries: make(map[string]Command)}
+	for _, c := range cmds {
+		l.entries[c.Nonce] = c
+	}
+	return replayTrust(base, l.sorted())
+}
+
+func TestRevokedKeyCannotBackdateGrants(t *testing.T) {
+	root, stolen, friend, thief := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	base := types.TrustSet{Keys: []types.TrustedKey{{Key: root.hex, Role: types.RoleAdmin}}}
+
+	grant := signedAt(t, root, Command{Kind: KindTrustAdd, Key: stolen.hex, Role: types.RoleAdmin}, 1)
+	honest := signedAt(t, stolen, Command{Kind: KindTrustAdd, Key: friend.hex, Role: types.RoleOperator}, 2)
+	revoke := signedAt(t, root, Command{Kind: KindTrustRevoke, Key: stolen.hex, Covers: []string{honest.Nonce}}, 10)
+	// Signed after the revocation but dated before it, as a leaked key would.
+	backdated := signedAt(t, stolen, Command{Kind: KindTrustAdd, Key: thief.hex, Role: types.RoleAdmin}, 3)
+
+	orders := [][]Command{
+		{grant, honest, revoke, backdated},
+		{backdated, revoke, honest, grant},
+		{revoke, backdated, grant, honest},
+	}
+	for i, cmds := range orders {
+		r := replayAll(base, cmds...)
+		if role := r.set.RoleOf(thief.hex); role != "" {
+			t.Errorf("order %d: backdated grant took effect, thief is %q", i, role)
+		}
+		if !r.void[backdated.Nonce] {
+			t.Errorf("order %d: backdated grant not void", i)
+		}
+		if role := r.set.RoleOf(friend.hex); role != types.RoleOperator {
+			t.Errorf("order %d: covered grant lost, friend is %q", i, role)
+		}
+		if role := r.set.RoleOf(stolen.hex); role != "" {
+			t.Errorf("order %d: revoked key is still %q", i, role)
+		}
+	}
+
+	// Without Covers the revocation takes everything the key signed with it.
+	bare := signedAt(t, root, Command{Kind: KindTrustRevoke, Key: stolen.hex}, 11)
+	r := replayAll(base, grant, honest, bare)
+	if role := r.set.RoleOf(friend.hex); role != "" || !r.void[honest.Nonce] {
+		t.Errorf("uncovered grant still stands: friend is %q", role)
+	}
+	if r.void[bare.Nonce] || r.void[grant.Nonce] {
+		t.Errorf("revocation voided commands the revoked key did not sign")
+	}
+}
+
+func TestUntrustedSignersAreNeverHeld(t *testing.T) {
+	root, stranger, other := newTestKey(t), newTestKey(t), newTestKey(t)
+	base := types.TrustSet{Keys: []types.TrustedKey{{Key: root.hex, Role: types.RoleAdmin}}}
+
+	junk := signedAt(t, stranger, Command{Kind: KindTrustAdd, Key: other.hex, Role: types.RoleAdmin}, 1)
+	r := replayAll(base, junk)
+	if r.everMayIssue(stranger.hex, KindTrustAdd) {
+		t.Errorf("untrusted signer may issue trust commands")
+	}
+	if r.skipped[junk.Nonce] == nil || r.set.RoleOf(other.hex) != "" {
+		t.Errorf("grant by an untrusted key took effect")
+	}
+
+	// A key trusted only for a while keeps what it signed while it was trusted.
+	grant := signedAt(t, root, Command{Kind: KindTrustAdd, Key: stranger.hex, Role: types.RoleAdmin}, 0)
+	r = replayAll(base, grant, junk)
+	if !r.everMayIssue(stranger.hex, KindTrustAdd) || r.set.RoleOf(other.hex) != types.RoleAdmin {
+		t.Errorf("grant by a trusted admin did not take effect")
+	}
+}
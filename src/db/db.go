@@ -0,0 +1,200 @@
+// Package db provides the node's embedded key-value database, a bbolt file under storage.database_dir.
+// It owns the bucket layout and schema migrations; other packages read and write through the helpers here.
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// Top-level buckets.
+const (
+	BucketMeta        = "meta"         // schema version and node metadata
+	BucketPeers       = "peers"        // NodeID -> PeerEntry JSON
+	BucketPeerHistory = "peer_history" // NodeID -> nested bucket of timestamped address changes
+	BucketBans        = "bans"         // NodeID or IP -> ban record
+	BucketTasks       = "tasks"        // task ID -> queued task
+	BucketReputation  = "reputation"   // NodeID -> reputation record
+	BucketNonces      = "admin_nonces" // admin command nonce -> expiry (replay protection)
+	BucketKV          = "kv"           // replicated key -> kv.Entry JSON
+)
+
+// fileName is the database file inside storage.database_dir.
+const fileName = "nexus.db"
+
+// schemaVersionKey holds the last applied migration in BucketMeta.
+var schemaVersionKey = []byte("schema_version")
+
+var (
+	handle  *bolt.DB
+	openErr error
+	once    sync.Once
+	mu      sync.Mutex
+)
+
+// Open returns the process-wide database, creating and migrating it on first use.
+func Open() (*bolt.DB, error) {
+	once.Do(func() {
+		path := Path()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			openErr = err
+			return
+		}
+		d, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+		if err != nil {
+			openErr = fmt.Errorf("open %s: %w", path, err)
+			return
+		}
+		if err := migrate(d); err != nil {
+			d.Close()
+			openErr = err
+			return
+		}
+		mu.Lock()
+		handle = d
+		mu.Unlock()
+		logger.Log("INFO", "db", "Database opened at "+path)
+	})
+	return handle, openErr
+}
+
+// Path resolves the database file from storage.database_dir relative to the executable.
+func Path() string {
+	dir := fmt.Sprint(settings.Get("storage.database_dir"))
+	if !filepath.IsAbs(dir) {
+		exePath, _ := os.Executable()
+		dir = filepath.Join(filepath.Dir(exePath), dir)
+	}
+	return filepath.Join(dir, fileName)
+}
+
+// Close closes the database. Later calls to Open return an error.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if handle == nil {
+		return nil
+	}
+	err := handle.Close()
+	handle = nil
+	openErr = fmt.Errorf("database closed")
+	logger.Log("DEBUG", "db", "Database closed")
+	return err
+}
+
+// Update runs fn in a read-write transaction.
+func Update(fn func(tx *bolt.Tx) error) error {
+	d, err := Open()
+	if err != nil {
+		return err
+	}
+	return d.Update(fn)
+}
+
+// View runs fn in a read-only transaction.
+func View(fn func(tx *bolt.Tx) error) error {
+	d, err := Open()
+	if err != nil {
+		return err
+	}
+	return d.View(fn)
+}
+
+// PutJSON stores v as JSON under key in bucket.
+func PutJSON(bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).Put([]byte(key), data)
+	})
+}
+
+// GetJSON decodes the value under key in bucket into v and reports whether it existed.
+func GetJSON(bucket, key string, v any) (bool, error) {
+	var found bool
+	err := View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(bucket)).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, v)
+	})
+	return found, err
+}
+
+// Delete removes key from bucket.
+func Delete(bucket, key string) error {
+	return Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn for every key in bucket, in key order.
+func ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil // nested bucket
+			}
+			return fn(string(k), v)
+		})
+	})
+}
+
+// SetMeta stores a node metadata value.
+func SetMeta(key, value string) error {
+	return Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BucketMeta)).Put([]byte(key), []byte(value))
+	})
+}
+
+// GetMeta returns a node metadata value, or "" when unset.
+func GetMeta(key string) string {
+	var out string
+	_ = View(func(tx *bolt.Tx) error {
+		out = string(tx.Bucket([]byte(BucketMeta)).Get([]byte(key)))
+		return nil
+	})
+	return out
+}
+
+// SchemaVersion returns the last migration applied to the open database.
+func SchemaVersion() int {
+	var v int
+	_ = View(func(tx *bolt.Tx) error {
+		v = readSchemaVersion(tx)
+		return nil
+	})
+	return v
+}
+
+func readSchemaVersion(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(BucketMeta))
+	if b == nil {
+		return 0
+	}
+	raw := b.Get(schemaVersionKey)
+	if len(raw) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(raw))
+}
+
+func writeSchemaVersion(tx *bolt.Tx, v int) error {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], uint64(v))
+	return tx.Bucket([]byte(BucketMeta)).Put(schemaVersionKey, raw[:])
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"atsuko-nexus/src/logger"
+)
+
+// migration upgrades the schema by one version inside a single transaction.
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *bolt.Tx) error
+}
+
+// migrations must stay in ascending version order; never edit one that has shipped, append a new one.
+var migrations = []migration{
+	{1, "create core buckets", func(tx *bolt.Tx) error {
+		return createBuckets(tx, BucketMeta, BucketPeers, BucketPeerHistory, BucketBans, BucketTasks)
+	}},
+	{2, "create reputation bucket", func(tx *bolt.Tx) error {
+		return createBuckets(tx, BucketReputation)
+	}},
+	{3, "create admin nonce bucket", func(tx *bolt.Tx) error {
+		return createBuckets(tx, BucketNonces)
+	}},
+	{4, "create replicated kv bucket", func(tx *bolt.Tx) error {
+		return createBuckets(tx, BucketKV)
+	}},
+}
+
+// migrate applies every migration newer than the stored schema version.
+func migrate(d *bolt.DB) error {
+	return d.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx, BucketMeta); err != nil {
+			return err
+		}
+		current := readSchemaVersion(tx)
+		latest := migrations[len(migrations)-1].version
+		if current > latest {
+			return fmt.Errorf("database schema v%d is newer than this build supports (v%d)", current, latest)
+		}
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			if err := m.apply(tx); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			if err := writeSchemaVersion(tx, m.version); err != nil {
+				return err
+			}
+			logger.Log("INFO", "db", fmt.Sprintf("Applied migration %d: %s", m.version, m.name))
+		}
+		return nil
+	})
+}
+
+// createBuckets creates each named top-level bucket if it does not exist.
+func createBuckets(tx *bolt.Tx, names ...string) error {
+	for _, name := range names {
+		if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+// Package election picks exactly one owner for each named scope (a bot shard, a job family) among
+// the nodes eligible to lead. Every node computes the same answer independently: the live
+// candidates are ranked per scope by a hash of scope and NodeID (rendezvous hashing), so scopes
+// spread across candidates while each has a single, agreed leader. A node that stops refreshing
+// its peer record drops out of the candidate set and the next in line takes over; a newcomer
+// that outranks the current leader only takes over once the view has been stable for SettleTime.
+package election
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// Membership is the view of the network the elector works from. Self returns the local NodeID;
+// Candidates returns the NodeIDs currently eligible and alive, including Self when it is eligible.
+type Membership interface {
+	Self() string
+	Candidates() []string
+}
+
+// Change describes a new leader for a scope.
+type Change struct {
+	Scope    string
+	Leader   string // "" when no candidate is alive
+	Previous string
+	IsLeader bool // whether the local node now leads the scope
+}
+
+// Config tunes an Elector.
+type Config struct {
+	Interval   time.Duration // time between re-evaluations
+	SettleTime time.Duration // how long a new top candidate must persist before a live leader is replaced
+}
+
+// ConfigFromSettings reads the election.* settings.
+func ConfigFromSettings() Config {
+	return Config{
+		Interval:   time.Duration(intSetting("election.interval", 15)) * time.Second,
+		SettleTime: time.Duration(intSetting("election.settle_time", 30)) * time.Second,
+	}
+}
+
+func intSetting(key string, def int) int {
+	if n, ok := settings.Get(key).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
+
+// scopeState is the elector's view of one scope.
+type scopeState struct {
+	leader       string
+	pending      string    // top-ranked candidate waiting to replace a live leader
+	pendingSince time.Time // when pending first ranked first
+	watchers     []func(Change)
+}
+
+// Elector tracks leadership of the scopes it has been asked about.
+type Elector struct {
+	members Membership
+	cfg     Config
+	now     func() time.Time
+
+	mu     sync.Mutex
+	scopes map[string]*scopeState
+
+	// evalMu serialises evaluations so callbacks see changes in order.
+	evalMu sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	defaultElector *Elector
+	defaultMu      sync.RWMutex
+)
+
+// New returns an Elector working from m.
+func New(m Membership, cfg Config) *Elector {
+	return &Elector{members: m, cfg: cfg, now: time.Now, scopes: make(map[string]*scopeState)}
+}
+
+// SetDefault installs e as the process-wide elector used by IsLeader and Watch.
+func SetDefault(e *Elector) {
+	defaultMu.Lock()
+	defaultElector = e
+	defaultMu.Unlock()
+}
+
+// Default returns the process-wide elector, or nil before the network layer has created one.
+func Default() *Elector {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultElector
+}
+
+// IsLeader reports whether this node leads scope according to the default elector.
+// It is false when no elector is running.
+func IsLeader(scope string) bool {
+	e := Default()
+	return e != nil && e.IsLeader(scope)
+}
+
+// Watch registers fn on the default elector; see Elector.Watch. It reports false when no elector is running.
+func Watch(scope string, fn func(Change)) bool {
+	e := Default()
+	if e == nil {
+		return false
+	}
+	e.Watch(scope, fn)
+	return true
+}
+
+// IsLeader reports whether the local node leads scope. The first call for a scope elects its
+// leader on the spot; later calls return the state of the last evaluation.
+func (e *Elector) IsLeader(scope string) bool {
+	leader, _ := e.Leader(scope)
+	return leader != "" && leader == e.members.Self()
+}
+
+// Leader returns the current leader of scope and whether there is one.
+func (e *Elector) Leader(scope string) (string, bool) {
+	e.mu.Lock()
+	_, known := e.scopes[scope]
+	e.mu.Unlock()
+	if !known {
+		e.track(scope)
+		e.Evaluate()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	leader := e.scopes[scope].leader
+	return leader, leader != ""
+}
+
+// Watch calls fn every time the leader of scope changes, starting with the first election.
+// Callbacks run on whichever goroutine triggered the evaluation, one at a time; they should return
+// quickly and must not start tracking new scopes.
+func (e *Elector) Watch(scope string, fn func(Change)) {
+	st := e.track(scope)
+	e.mu.Lock()
+	st.watchers = append(st.watchers, fn)
+	e.mu.Unlock()
+	e.Evaluate()
+}
+
+// track returns the state for scope, creating it if needed.
+func (e *Elector) track(scope string) *scopeState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.scopes[scope]
+	if !ok {
+		st = &scopeState{}
+		e.scopes[scope] = st
+	}
+	return st
+}
+
+// Evaluate re-runs the election for every tracked scope against the current membership and
+// fires callbacks for scopes whose leader changed.
+func (e *Elector) Evaluate() {
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+
+	candidates := e.members.Candidates()
+	alive := make(map[string]bool, len(candidates))
+	for _, id := range candidates {
+		alive[id] = true
+	}
+	self := e.members.Self()
+	now := e.now()
+
+	type fired struct {
+		change   Change
+		watchers []func(Change)
+	}
+	var changes []fired
+
+	e.mu.Lock()
+	for scope, st := range e.scopes {
+		top := rank(scope, candidates)
+		next := st.leader
+		switch {
+		case top == st.leader:
+			st.pending = ""
+		case st.leader == "" || !alive[st.leader]:
+			// No leader or it dropped out: the top candidate takes over at once.
+			next = top
+			st.pending = ""
+		case st.pending != top:
+			// A live leader is outranked; wait for the view to settle before handing over.
+			st.pending, st.pendingSince = top, now
+		case now.Sub(st.pendingSince) >= e.cfg.SettleTime:
+			next = top
+			st.pending = ""
+		}
+		if next == st.leader {
+			continue
+		}
+		c := Change{Scope: scope, Leader: next, Previous: st.leader, IsLeader: next != "" && next == self}
+		st.leader = next
+		changes = append(changes, fired{c, slices.Clone(st.watchers)})
+	}
+	e.mu.Unlock()
+
+	for _, f := range changes {
+		logger.Log("INFO", "election", describe(f.change))
+		for _, fn := range f.watchers {
+			fn(f.change)
+		}
+	}
+}
+
+// rank returns the candidate with the highest hash of scope and NodeID, or "" if there are none.
+// Ties, which need a hash collision, go to the lower NodeID.
+func rank(scope string, candidates []string) string {
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	var best string
+	var bestScore [sha256.Size]byte
+	for _, id := range sorted {
+		score := sha256.Sum256([]byte(scope + "\x00" + id))
+		if best == "" || string(score[:]) > string(bestScore[:]) {
+			best, bestScore = id, score
+		}
+	}
+	return best
+}
+
+// describe is the log line for a leadership change.
+func describe(c Change) string {
+	switch {
+	case c.Leader == "":
+		return fmt.Sprintf("Scope %q has no leader (no live candidates)", c.Scope)
+	case c.IsLeader:
+		return fmt.Sprintf("This node now leads scope %q", c.Scope)
+	default:
+		return fmt.Sprintf("Scope %q is now led by %s", c.Scope, c.Leader)
+	}
+}
+
+func (e *Elector) Name() string { return "election" }
+
+// Start re-evaluates every Interval until Stop.
+func (e *Elector) Start(ctx context.Context) error {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Evaluate()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the evaluation loop.
+func (e *Elector) Stop(ctx context.Context) error {
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
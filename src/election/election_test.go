@@ -0,0 +1,213 @@
+package election
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMembership is a shared, mutable view of which nodes are alive and eligible.
+type fakeMembership struct {
+	mu         sync.Mutex
+	candidates []string
+}
+
+func (f *fakeMembership) set(ids ...string) {
+	f.mu.Lock()
+	f.candidates = append([]string(nil), ids...)
+	f.mu.Unlock()
+}
+
+func (f *fakeMembership) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.candidates...)
+}
+
+// nodeView is one node's Membership: its own ID over the shared view, in an order of its own.
+type nodeView struct {
+	self    string
+	shared  *fakeMembership
+	reverse bool
+}
+
+func (v nodeView) Self() string { return v.self }
+
+func (v nodeView) Candidates() []string {
+	out := v.shared.list()
+	if v.reverse {
+		slices.Reverse(out)
+	}
+	return out
+}
+
+// fakeClock is the injected now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func newElector(m Membership, clock *fakeClock) *Elector {
+	e := New(m, Config{Interval: time.Hour, SettleTime: 30 * time.Second})
+	e.now = clock.now
+	return e
+}
+
+// outranking returns a NodeID, not among ids, that would lead scope if it joined them.
+func outranking(scope string, ids []string) string {
+	for i := 0; ; i++ {
+		id := fmt.Sprintf("newcomer-%d", i)
+		if rank(scope, append(slices.Clone(ids), id)) == id {
+			return id
+		}
+	}
+}
+
+func TestNodesAgreeOnLeaders(t *testing.T) {
+	shared := &fakeMembership{}
+	ids := []string{"a", "b", "c", "d", "e"}
+	shared.set(ids...)
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	electors := make([]*Elector, len(ids))
+	for i, id := range ids {
+		electors[i] = newElector(nodeView{self: id, shared: shared, reverse: i%2 == 1}, clock)
+	}
+
+	leaders := make(map[string]bool)
+	for s := 0; s < 20; s++ {
+		scope := fmt.Sprintf("shard-%d", s)
+		want, ok := electors[0].Leader(scope)
+		if !ok {
+			t.Fatalf("%s: no leader among %v", scope, ids)
+		}
+		leaders[want] = true
+		leading := 0
+		for i, e := range electors {
+			if got, _ := e.Leader(scope); got != want {
+				t.Errorf("%s: node %s sees leader %s, node a sees %s", scope, ids[i], got, want)
+			}
+			if e.IsLeader(scope) {
+				leading++
+			}
+		}
+		if leading != 1 {
+			t.Errorf("%s: %d nodes think they lead, want 1", scope, leading)
+		}
+	}
+	if len(leaders) < 2 {
+		t.Errorf("20 scopes all led by %v; want them spread across candidates", leaders)
+	}
+}
+
+func TestLeaderFailover(t *testing.T) {
+	shared := &fakeMembership{}
+	shared.set("a", "b", "c")
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	e := newElector(nodeView{self: "a", shared: shared}, clock)
+
+	leader, _ := e.Leader("jobs")
+	var rest []string
+	for _, id := range shared.list() {
+		if id != leader {
+			rest = append(rest, id)
+		}
+	}
+	shared.set(rest...)
+	e.Evaluate()
+	got, _ := e.Leader("jobs")
+	if got == leader || got != rank("jobs", rest) {
+		t.Errorf("after %s left: leader %s, want %s at once", leader, got, rank("jobs", rest))
+	}
+
+	shared.set()
+	e.Evaluate()
+	if got, ok := e.Leader("jobs"); ok {
+		t.Errorf("leader %s with no candidates", got)
+	}
+}
+
+func TestNewcomerWaitsForSettleTime(t *testing.T) {
+	shared := &fakeMembership{}
+	ids := []string{"a", "b", "c"}
+	shared.set(ids...)
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	e := newElector(nodeView{self: "a", shared: shared}, clock)
+	leader, _ := e.Leader("jobs")
+	newcomer := outranking("jobs", ids)
+
+	shared.set(append(slices.Clone(ids), newcomer)...)
+	e.Evaluate()
+	clock.advance(e.cfg.SettleTime - time.Second)
+	e.Evaluate()
+	if got, _ := e.Leader("jobs"); got != leader {
+		t.Fatalf("leader changed to %s before the view settled", got)
+	}
+
+	// The newcomer drops out and returns: the settle time starts over.
+	shared.set(ids...)
+	e.Evaluate()
+	shared.set(append(slices.Clone(ids), newcomer)...)
+	e.Evaluate()
+	clock.advance(e.cfg.SettleTime - time.Second)
+	e.Evaluate()
+	if got, _ := e.Leader("jobs"); got != leader {
+		t.Fatalf("leader changed to %s although the newcomer flapped", got)
+	}
+
+	clock.advance(time.Second)
+	e.Evaluate()
+	if got, _ := e.Leader("jobs"); got != newcomer {
+		t.Errorf("after the settle time leader is %s, want %s", got, newcomer)
+	}
+}
+
+func TestWatchCallbacks(t *testing.T) {
+	shared := &fakeMembership{}
+	shared.set("a", "b", "c")
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	leader := rank("jobs", shared.list())
+	e := newElector(nodeView{self: leader, shared: shared}, clock)
+
+	var changes []Change
+	e.Watch("jobs", func(c Change) { changes = append(changes, c) })
+	if len(changes) != 1 {
+		t.Fatalf("got %d callbacks on Watch, want 1", len(changes))
+	}
+	if c := changes[0]; c.Leader != leader || c.Previous != "" || !c.IsLeader {
+		t.Errorf("first change %+v, want %s leading with no previous leader", c, leader)
+	}
+
+	e.Evaluate()
+	if len(changes) != 1 {
+		t.Errorf("callback fired without a change: %+v", changes[1:])
+	}
+
+	var rest []string
+	for _, id := range shared.list() {
+		if id != leader {
+			rest = append(rest, id)
+		}
+	}
+	shared.set(rest...)
+	e.Evaluate()
+	if len(changes) != 2 {
+		t.Fatalf("got %d callbacks after failover, want 2", len(changes))
+	}
+	if c := changes[1]; c.Previous != leader || c.Leader != rank("jobs", rest) || c.IsLeader {
+		t.Errorf("failover change %+v, want %s taking over from %s", c, rank("jobs", rest), leader)
+	}
+}
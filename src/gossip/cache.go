@@ -0,0 +1,123 @@
+package gossip
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxCached bounds the cache so a flood of distinct messages cannot exhaust memory.
+const maxCached = 4096
+
+type cachedMessage struct {
+	msg     Message
+	expires time.Time
+}
+
+// seenCache remembers recent messages by ID for deduplication and to answer pulls.
+type seenCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	msgs map[string]cachedMessage
+}
+
+func newSeenCache(ttl time.Duration) *seenCache {
+	return &seenCache{ttl: ttl, msgs: make(map[string]cachedMessage)}
+}
+
+// add stores msg and reports whether it was new.
+func (c *seenCache) add(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.msgs[msg.ID]; ok {
+		return false
+	}
+	if len(c.msgs) >= maxCached {
+		c.expireLocked(time.Now())
+		if len(c.msgs) >= maxCached {
+			c.evictOldestLocked()
+		}
+	}
+	c.msgs[msg.ID] = cachedMessage{msg: msg, expires: time.Now().Add(c.ttl)}
+	return true
+}
+
+// has reports whether a message with id is cached.
+func (c *seenCache) has(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.msgs[id]
+	return ok
+}
+
+// ids lists the IDs currently cached.
+func (c *seenCache) ids() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, 0, len(c.msgs))
+	for id := range c.msgs {
+		out = append(out, id)
+	}
+	return out
+}
+
+// missing returns the cached messages whose IDs are not in have, newest first, stopping before
+// their encoded size passes maxBytes. A puller that gets a full reply receives the older rest on
+// later pulls, once it holds the newer ones.
+func (c *seenCache) missing(have []string, maxBytes int) []Message {
+	known := make(map[string]bool, len(have))
+	for _, id := range have {
+		known[id] = true
+	}
+	c.mu.Lock()
+	var candidates []cachedMessage
+	for id, cm := range c.msgs {
+		if !known[id] {
+			candidates = append(candidates, cm)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].expires.After(candidates[j].expires) })
+
+	var out []Message
+	size := 0
+	for _, cm := range candidates {
+		data, err := json.Marshal(cm.msg)
+		if err != nil {
+			continue
+		}
+		if size+len(data)+1 > maxBytes {
+			break
+		}
+		size += len(data) + 1
+		out = append(out, cm.msg)
+	}
+	return out
+}
+
+// expire drops entries past their TTL.
+func (c *seenCache) expire() {
+	c.mu.Lock()
+	c.expireLocked(time.Now())
+	c.mu.Unlock()
+}
+
+func (c *seenCache) expireLocked(now time.Time) {
+	for id, cm := range c.msgs {
+		if now.After(cm.expires) {
+			delete(c.msgs, id)
+		}
+	}
+}
+
+func (c *seenCache) evictOldestLocked() {
+	var oldest string
+	var at time.Time
+	for id, cm := range c.msgs {
+		if oldest == "" || cm.expires.Before(at) {
+			oldest, at = id, cm.expires
+		}
+	}
+	delete(c.msgs, oldest)
+}
@@ -0,0 +1,346 @@
+// Package gossip spreads small broadcast messages (announcements, admin notices, tasks) to every node.
+// Messages are pushed to a few random peers with a hop limit and pulled periodically to repair gaps;
+// a cache of seen message IDs stops them from circulating forever. The network underneath is abstracted
+// as a Transport so the layer can run over Nexus sessions or entirely in process.
+package gossip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// Message is one gossip envelope. ID is derived from the other fields, so a relayed copy keeps its identity.
+type Message struct {
+	ID      string          `json:"id"`
+	Topic   string          `json:"topic"`
+	Origin  string          `json:"origin"`
+	Created string          `json:"created"`
+	TTL     int             `json:"ttl"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Transport moves messages between nodes. Peers returns the NodeIDs currently reachable, Push delivers
+// a message to one peer, and Pull asks one peer for the recent messages whose IDs are not in have.
+type Transport interface {
+	Peers() []string
+	Push(nodeID string, msg Message) error
+	Pull(nodeID string, have []string) ([]Message, error)
+}
+
+// MaxPullBytes caps the encoded messages in one pull reply, well below the Nexus frame limit.
+const MaxPullBytes = 1 << 20
+
+// Handler receives a delivered message along with the NodeID it arrived from (our own ID for local publishes).
+type Handler func(from string, msg Message)
+
+// Validator checks a received message before it is cached, delivered or forwarded. A message
+// that fails is dropped, so it goes no further than the node that rejected it.
+type Validator func(msg Message) error
+
+// Config tunes a Node.
+type Config struct {
+	Fanout       int           // peers each new message is pushed to
+	TTL          int           // hops a published message may travel
+	PullInterval time.Duration // time between anti-entropy pulls
+	CacheTTL     time.Duration // how long message IDs (and bodies, for pulls) are remembered
+	MaxSkew      time.Duration // how far in the future a message's Created time may lie
+}
+
+// ConfigFromSettings reads the gossip.* settings.
+func ConfigFromSettings() Config {
+	return Config{
+		Fanout:       intSetting("gossip.fanout", 3),
+		TTL:          intSetting("gossip.ttl", 8),
+		PullInterval: time.Duration(intSetting("gossip.pull_interval", 30)) * time.Second,
+		CacheTTL:     time.Duration(intSetting("gossip.cache_ttl", 600)) * time.Second,
+		MaxSkew:      hlc.MaxSkew(),
+	}
+}
+
+func intSetting(key string, def int) int {
+	if n, ok := settings.Get(key).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
+
+// Node is one participant in the gossip network.
+type Node struct {
+	self      string
+	transport Transport
+	cfg       Config
+	cache     *seenCache
+
+	mu         sync.RWMutex
+	handlers   map[string][]Handler
+	validators map[string]Validator
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	defaultNode *Node
+	defaultMu   sync.RWMutex
+)
+
+// New returns a Node for the local NodeID self that talks through t.
+func New(self string, t Transport, cfg Config) *Node {
+	return &Node{
+		self:      self,
+		transport: t,
+		cfg:       cfg,
+		cache:     newSeenCache(cfg.CacheTTL),
+		handlers:   make(map[string][]Handler),
+		validators: make(map[string]Validator),
+	}
+}
+
+// SetDefault installs n as the process-wide node returned by Default.
+func SetDefault(n *Node) {
+	defaultMu.Lock()
+	defaultNode = n
+	defaultMu.Unlock()
+}
+
+// Default returns the process-wide node, or nil before the network layer has created one.
+func Default() *Node {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultNode
+}
+
+// Self returns the local NodeID.
+func (n *Node) Self() string { return n.self }
+
+// Subscribe registers h for every message on topic, whether published locally or received.
+func (n *Node) Subscribe(topic string, h Handler) {
+	n.mu.Lock()
+	n.handlers[topic] = append(n.handlers[topic], h)
+	n.mu.Unlock()
+}
+
+// Validate installs v as the check for received messages on topic, replacing any earlier one.
+func (n *Node) Validate(topic string, v Validator) {
+	n.mu.Lock()
+	n.validators[topic] = v
+	n.mu.Unlock()
+}
+
+// On subscribes a typed handler: each payload on topic is decoded into T before fn is called.
+// Payloads that do not decode are logged and dropped.
+func On[T any](n *Node, topic string, fn func(from string, v T)) {
+	n.Subscribe(topic, func(from string, msg Message) {
+		var v T
+		if err := json.Unmarshal(msg.Payload, &v); err != nil {
+			logger.Log("DEBUG", "gossip", fmt.Sprintf("Dropping %s message %s: %v", topic, msg.ID, err))
+			return
+		}
+		fn(from, v)
+	})
+}
+
+// Publish encodes v as a new message on topic, delivers it locally and pushes it to Fanout peers.
+func (n *Node) Publish(topic string, v any) (Message, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		Topic:   topic,
+		Origin:  n.self,
+		Created: time.Now().UTC().Format(time.RFC3339Nano),
+		TTL:     n.cfg.TTL,
+		Payload: payload,
+	}
+	msg.ID = messageID(msg)
+
+	n.cache.add(msg)
+	n.deliver(n.self, msg)
+	n.push(msg, "")
+	return msg, nil
+}
+
+// HandlePush is called by the transport when a peer pushes msg to us. New messages are delivered
+// and, while hops remain, forwarded to Fanout other peers. It reports whether msg was new.
+func (n *Node) HandlePush(from string, msg Message) bool {
+	if !n.acceptable(msg) {
+		logger.Log("DEBUG", "gossip", fmt.Sprintf("Dropping message %s from %s (bad ID, expired or future-dated)", msg.ID, from))
+		return false
+	}
+	if n.cache.has(msg.ID) {
+		return false
+	}
+	if err := n.validate(msg); err != nil {
+		logger.Log("WARN", "gossip", fmt.Sprintf("Dropping %s message %s from %s: %v", msg.Topic, msg.ID, from, err))
+		return false
+	}
+	if !n.cache.add(msg) {
+		return false
+	}
+	n.deliver(from, msg)
+	if msg.TTL > 1 {
+		msg.TTL--
+		n.push(msg, from)
+	}
+	return true
+}
+
+// HandlePull is called by the transport when a peer asks for the messages it is missing. It
+// returns the newest of them up to MaxPullBytes; the puller gets the rest on later rounds.
+func (n *Node) HandlePull(have []string) []Message {
+	return n.cache.missing(have, MaxPullBytes)
+}
+
+// Pull asks one random peer for messages we have not seen and delivers them. Pulled messages are
+// not forwarded further; the pushes that will reach our peers, or their own pulls, cover them.
+func (n *Node) Pull() {
+	peers := n.transport.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[rand.Intn(len(peers))]
+	msgs, err := n.transport.Pull(peer, n.cache.ids())
+	if err != nil {
+		logger.Log("DEBUG", "gossip", fmt.Sprintf("Pull from %s failed: %v", peer, err))
+		return
+	}
+	got := 0
+	for _, msg := range msgs {
+		if !n.acceptable(msg) || n.cache.has(msg.ID) {
+			continue
+		}
+		if err := n.validate(msg); err != nil {
+			logger.Log("WARN", "gossip", fmt.Sprintf("Dropping pulled %s message %s from %s: %v", msg.Topic, msg.ID, peer, err))
+			continue
+		}
+		if !n.cache.add(msg) {
+			continue
+		}
+		n.deliver(peer, msg)
+		got++
+	}
+	if got > 0 {
+		logger.Log("DEBUG", "gossip", fmt.Sprintf("Pulled %d missed messages from %s", got, peer))
+	}
+}
+
+// push sends msg to up to Fanout random peers, skipping the one it came from.
+func (n *Node) push(msg Message, except string) {
+	var targets []string
+	for _, p := range n.transport.Peers() {
+		if p != except && p != n.self && p != msg.Origin {
+			targets = append(targets, p)
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > n.cfg.Fanout {
+		targets = targets[:n.cfg.Fanout]
+	}
+	// Push to all targets at once so the message spreads breadth-first; a slow or unreachable
+	// peer must not delay the others.
+	var wg sync.WaitGroup
+	for _, p := range targets {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if err := n.transport.Push(p, msg); err != nil {
+				logger.Log("DEBUG", "gossip", fmt.Sprintf("Push of %s to %s failed: %v", msg.ID, p, err))
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// validate runs the validator installed for msg.Topic, if any.
+func (n *Node) validate(msg Message) error {
+	n.mu.RLock()
+	v := n.validators[msg.Topic]
+	n.mu.RUnlock()
+	if v == nil {
+		return nil
+	}
+	return v(msg)
+}
+
+// deliver runs the handlers subscribed to msg.Topic.
+func (n *Node) deliver(from string, msg Message) {
+	n.mu.RLock()
+	hs := append([]Handler(nil), n.handlers[msg.Topic]...)
+	n.mu.RUnlock()
+	for _, h := range hs {
+		h(from, msg)
+	}
+}
+
+// Name identifies the service in lifecycle logs.
+func (n *Node) Name() string { return "gossip" }
+
+// Start runs the periodic pull and cache expiry until Stop.
+func (n *Node) Start(ctx context.Context) error {
+	ctx, n.cancel = context.WithCancel(ctx)
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		ticker := time.NewTicker(n.cfg.PullInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n.cache.expire()
+				n.Pull()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the pull loop.
+func (n *Node) Stop(ctx context.Context) error {
+	if n.cancel == nil {
+		return nil
+	}
+	n.cancel()
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acceptable rejects messages whose ID does not match their contents and messages older than
+// CacheTTL, which the dedup cache may already have forgotten and would otherwise deliver twice.
+// Messages dated more than MaxSkew in the future are rejected too: they would never age out and
+// would be delivered again each time the cache forgot them.
+func (n *Node) acceptable(msg Message) bool {
+	if msg.ID != messageID(msg) {
+		return false
+	}
+	created, err := time.Parse(time.RFC3339Nano, msg.Created)
+	if err != nil {
+		return false
+	}
+	age := time.Since(created)
+	return age < n.cfg.CacheTTL && -age <= n.cfg.MaxSkew
+}
+
+// messageID hashes the immutable fields of msg; TTL is excluded because it changes per hop.
+func messageID(msg Message) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", msg.Topic, msg.Origin, msg.Created)
+	h.Write(msg.Payload)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
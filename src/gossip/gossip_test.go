@@ -0,0 +1,296 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memNet connects Nodes in process. links maps each node to the peers it can reach; a node
+// missing from links reaches every other node.
+type memNet struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	links map[string][]string
+}
+
+func newMemNet() *memNet {
+	return &memNet{nodes: make(map[string]*Node), links: make(map[string][]string)}
+}
+
+// memTransport is one node's view of a memNet.
+type memTransport struct {
+	net  *memNet
+	self string
+}
+
+func (t memTransport) Peers() []string {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	if peers, ok := t.net.links[t.self]; ok {
+		return append([]string(nil), peers...)
+	}
+	var out []string
+	for id := range t.net.nodes {
+		if id != t.self {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (t memTransport) node(id string) (*Node, error) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	n, ok := t.net.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown node %s", id)
+	}
+	return n, nil
+}
+
+func (t memTransport) Push(id string, msg Message) error {
+	n, err := t.node(id)
+	if err != nil {
+		return err
+	}
+	n.HandlePush(t.self, msg)
+	return nil
+}
+
+func (t memTransport) Pull(id string, have []string) ([]Message, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandlePull(have), nil
+}
+
+// counter records how often each node delivered each message.
+type counter struct {
+	mu   sync.Mutex
+	seen map[string]map[string]int // node -> message ID -> deliveries
+}
+
+func (c *counter) get(node, id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seen[node][id]
+}
+
+// cluster creates n nodes named n0..n{n-1} on net, all subscribed to "test".
+func cluster(net *memNet, n int, cfg Config) ([]*Node, *counter) {
+	c := &counter{seen: make(map[string]map[string]int)}
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		id := fmt.Sprintf("n%d", i)
+		node := New(id, memTransport{net: net, self: id}, cfg)
+		c.seen[id] = make(map[string]int)
+		node.Subscribe("test", func(_ string, msg Message) {
+			c.mu.Lock()
+			c.seen[id][msg.ID]++
+			c.mu.Unlock()
+		})
+		net.mu.Lock()
+		net.nodes[id] = node
+		net.mu.Unlock()
+		nodes[i] = node
+	}
+	return nodes, c
+}
+
+func testConfig() Config {
+	return Config{Fanout: 3, TTL: 8, PullInterval: time.Hour, CacheTTL: time.Minute, MaxSkew: time.Minute}
+}
+
+func TestPublishDeliversOncePerNode(t *testing.T) {
+	cfg := testConfig()
+	cfg.Fanout = 10 // every node pushes to every other, so each receives many copies
+	nodes, c := cluster(newMemNet(), 8, cfg)
+
+	msg, err := nodes[0].Publish("test", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		if got := c.get(n.Self(), msg.ID); got != 1 {
+			t.Errorf("%s delivered %d times, want 1", n.Self(), got)
+		}
+	}
+}
+
+func TestTTLLimitsHops(t *testing.T) {
+	net := newMemNet()
+	cfg := testConfig()
+	cfg.TTL = 3
+	nodes, c := cluster(net, 6, cfg)
+	// A line: n0 -> n1 -> ... -> n5
+	for i := 0; i < len(nodes)-1; i++ {
+		net.links[nodes[i].Self()] = []string{nodes[i+1].Self()}
+	}
+	net.links[nodes[len(nodes)-1].Self()] = nil
+
+	msg, err := nodes[0].Publish("test", "hops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range nodes {
+		want := 0
+		if i <= cfg.TTL {
+			want = 1
+		}
+		if got := c.get(n.Self(), msg.ID); got != want {
+			t.Errorf("%s delivered %d times, want %d", n.Self(), got, want)
+		}
+	}
+}
+
+func TestPullRepairsMissedMessages(t *testing.T) {
+	net := newMemNet()
+	nodes, c := cluster(net, 3, testConfig())
+	a, b, late := nodes[0], nodes[1], nodes[2]
+	// late is cut off while the message spreads
+	net.links[a.Self()] = []string{b.Self()}
+	net.links[b.Self()] = []string{a.Self()}
+	net.links[late.Self()] = nil
+
+	msg, err := a.Publish("test", "missed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.get(late.Self(), msg.ID); got != 0 {
+		t.Fatalf("partitioned node delivered %d times", got)
+	}
+
+	net.links[late.Self()] = []string{b.Self()}
+	late.Pull()
+	if got := c.get(late.Self(), msg.ID); got != 1 {
+		t.Fatalf("after pull delivered %d times, want 1", got)
+	}
+	late.Pull()
+	if got := c.get(late.Self(), msg.ID); got != 1 {
+		t.Fatalf("second pull redelivered: %d deliveries", got)
+	}
+}
+
+func TestRejectsStaleFutureAndTampered(t *testing.T) {
+	nodes, c := cluster(newMemNet(), 1, testConfig())
+	n := nodes[0]
+	stamp := func(created time.Time) Message {
+		msg := Message{
+			Topic:   "test",
+			Origin:  "elsewhere",
+			Created: created.UTC().Format(time.RFC3339Nano),
+			TTL:     1,
+			Payload: json.RawMessage(`"x"`),
+		}
+		msg.ID = messageID(msg)
+		return msg
+	}
+
+	if !n.HandlePush("peer", stamp(time.Now())) {
+		t.Error("current message rejected")
+	}
+	if n.HandlePush("peer", stamp(time.Now().Add(-2*time.Minute))) {
+		t.Error("message older than CacheTTL accepted")
+	}
+	future := stamp(time.Now().Add(time.Hour))
+	if n.HandlePush("peer", future) {
+		t.Error("message dated an hour ahead accepted")
+	}
+	if c.get(n.Self(), future.ID) != 0 {
+		t.Error("future-dated message delivered")
+	}
+	tampered := stamp(time.Now())
+	tampered.Payload = json.RawMessage(`"y"`)
+	if n.HandlePush("peer", tampered) {
+		t.Error("message with mismatched ID accepted")
+	}
+}
+
+func TestValidatorStopsForwarding(t *testing.T) {
+	net := newMemNet()
+	nodes, c := cluster(net, 3, testConfig())
+	for _, n := range nodes {
+		n.Validate("test", func(msg Message) error {
+			if string(msg.Payload) == `"forged"` {
+				return fmt.Errorf("forged")
+			}
+			return nil
+		})
+	}
+	// n0 -> n1 -> n2
+	net.links["n0"] = []string{"n1"}
+	net.links["n1"] = []string{"n2"}
+	net.links["n2"] = nil
+
+	// Local publishes are not validated, so n0 stands in for a node that forwards without checking.
+	msg, err := nodes[0].Publish("test", "forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"n1", "n2"} {
+		if got := c.get(id, msg.ID); got != 0 {
+			t.Errorf("%s delivered a message its validator rejects", id)
+		}
+	}
+	if got := nodes[1].HandlePull(nil); len(got) != 0 {
+		t.Errorf("rejected message was cached and would be served to pulls: %v", got)
+	}
+
+	ok, err := nodes[0].Publish("test", "genuine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.get("n2", ok.ID) != 1 {
+		t.Error("valid message not forwarded")
+	}
+}
+
+func TestPullRepliesAreBoundedAndPage(t *testing.T) {
+	net := newMemNet()
+	nodes, c := cluster(net, 2, testConfig())
+	src, late := nodes[0], nodes[1]
+	net.links[src.Self()] = nil
+	net.links[late.Self()] = nil
+
+	// Far more than one reply can carry: 80 messages of 48 KiB each.
+	body := strings.Repeat("x", 48<<10)
+	var ids []string
+	for i := 0; i < 80; i++ {
+		msg, err := src.Publish("test", fmt.Sprintf("%d:%s", i, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	reply := src.HandlePull(nil)
+	data, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > MaxPullBytes+2 || len(reply) == 0 || len(reply) == len(ids) {
+		t.Fatalf("reply has %d of %d messages in %d bytes, cap %d", len(reply), len(ids), len(data), MaxPullBytes)
+	}
+
+	net.links[late.Self()] = []string{src.Self()}
+	for round := 0; round < len(ids); round++ {
+		late.Pull()
+		done := true
+		for _, id := range ids {
+			if c.get(late.Self(), id) == 0 {
+				done = false
+				break
+			}
+		}
+		if done {
+			t.Logf("caught up after %d pulls", round+1)
+			return
+		}
+	}
+	t.Fatal("puller never caught up")
+}
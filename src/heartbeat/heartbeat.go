@@ -0,0 +1,128 @@
+// Package heartbeat periodically logs that the node is alive, along with CPU, RAM and network metrics when enabled.
+// It runs independently of the user interface so headless nodes report the same heartbeat as interactive ones.
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+
+	"atsuko-nexus/src/lifecycle"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+var (
+	lastBytesSent uint64    // Used to track network upload delta
+	lastBytesRecv uint64    // Used to track network download delta
+	lastNetTime   time.Time // Last time network was sampled
+)
+
+// Service initializes the network counters and returns a service that logs a heartbeat every
+// metrics.heartbeat_interval seconds.
+func Service() lifecycle.Service {
+	logger.Log("DEBUG", "heartbeat", "Initializing network counters...")
+	lastNetTime = time.Now()
+	if counters, _ := net.IOCounters(false); len(counters) > 0 {
+		lastBytesSent = counters[0].BytesSent
+		lastBytesRecv = counters[0].BytesRecv
+		logger.Log("DEBUG", "heartbeat", fmt.Sprintf("Initial Bytes Sent: %d, Bytes Recv: %d", lastBytesSent, lastBytesRecv))
+	}
+
+	return lifecycle.Loop("heartbeat", interval, false, func(ctx context.Context) {
+		Beat()
+	})
+}
+
+// interval returns the configured heartbeat interval, defaulting to two minutes.
+func interval() time.Duration {
+	raw := settings.Get("metrics.heartbeat_interval")
+	if sec, ok := raw.(int); ok && sec > 0 {
+		return time.Duration(sec) * time.Second
+	} else if fsec, ok := raw.(float64); ok && fsec > 0 {
+		return time.Duration(fsec) * time.Second
+	}
+	return 120 * time.Second
+}
+
+// Beat collects the enabled metrics and logs a single heartbeat line.
+func Beat() {
+	logMsg := "Node still alive"
+	logger.Log("DEBUG", "heartbeat", "Heartbeat due, collecting metrics...")
+
+	if settings.Get("metrics.enable_metrics") == true {
+		parts := []string{}
+
+		if settings.Get("metrics.cpu_monitoring") == true {
+			if usage, _ := cpu.Percent(0, false); len(usage) > 0 {
+				logger.Log("DEBUG", "heartbeat", fmt.Sprintf("CPU usage: %.1f%%", usage[0]))
+				parts = append(parts, fmt.Sprintf("CPU: %.1f%%", usage[0]))
+			}
+		}
+
+		if settings.Get("metrics.ram_monitoring") == true {
+			if vmStat, _ := mem.VirtualMemory(); vmStat != nil {
+				logger.Log("DEBUG", "heartbeat", fmt.Sprintf("RAM usage: %.1f%% (%s/%s)",
+					vmStat.UsedPercent,
+					FormatBytes(vmStat.Used),
+					FormatBytes(vmStat.Total)))
+				parts = append(parts, fmt.Sprintf("RAM: %.1f%% (%s/%s)",
+					vmStat.UsedPercent,
+					FormatBytes(vmStat.Used),
+					FormatBytes(vmStat.Total),
+				))
+			}
+		}
+
+		if settings.Get("metrics.net_traffic_monitoring") == true {
+			if ioStat, _ := net.IOCounters(false); len(ioStat) > 0 {
+				now := time.Now()
+				elapsed := now.Sub(lastNetTime).Seconds()
+
+				deltaSent := float64(ioStat[0].BytesSent - lastBytesSent)
+				deltaRecv := float64(ioStat[0].BytesRecv - lastBytesRecv)
+
+				upRate := deltaSent / elapsed
+				downRate := deltaRecv / elapsed
+
+				logger.Log("DEBUG", "heartbeat", fmt.Sprintf("Net ↑ %s/s ↓ %s/s",
+					FormatBytes(uint64(upRate)),
+					FormatBytes(uint64(downRate))))
+
+				lastBytesSent = ioStat[0].BytesSent
+				lastBytesRecv = ioStat[0].BytesRecv
+				lastNetTime = now
+
+				parts = append(parts, fmt.Sprintf("Net: ↑ %s/s ↓ %s/s",
+					FormatBytes(uint64(upRate)),
+					FormatBytes(uint64(downRate)),
+				))
+			}
+		}
+
+		if len(parts) > 0 {
+			logMsg = strings.Join(parts, " | ")
+		}
+	}
+
+	logger.Log("INFO", "heartbeat", logMsg)
+}
+
+// FormatBytes converts a byte count into a human-readable string with units.
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
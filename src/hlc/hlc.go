@@ -0,0 +1,215 @@
+// Package hlc implements hybrid logical clocks. A Timestamp pairs the physical time in milliseconds
+// with a logical counter, so timestamps from one node always increase and a node that receives a
+// timestamp never issues an older one afterwards, even if its wall clock lags the sender's. Remote
+// timestamps further ahead of our wall clock than the clock's skew bound are refused instead of
+// being adopted, so a single node with a badly wrong clock cannot drag everyone else forward.
+package hlc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/settings"
+)
+
+// ErrClockSkew is returned for a remote timestamp too far ahead of the local wall clock.
+var ErrClockSkew = errors.New("timestamp too far in the future")
+
+// maxLogical is the largest logical counter. It keeps the counter inside the sub-millisecond
+// digits of the RFC3339 form; once reached, the clock moves on to the next millisecond.
+const maxLogical = 999_999
+
+// Timestamp is one hybrid logical clock reading. The zero Timestamp is older than every other.
+type Timestamp struct {
+	Wall    int64  // milliseconds since the Unix epoch
+	Logical uint32 // orders events within the same millisecond
+}
+
+// FromTime returns the timestamp for t with a zero logical counter.
+func FromTime(t time.Time) Timestamp {
+	if t.IsZero() {
+		return Timestamp{}
+	}
+	return Timestamp{Wall: t.UnixMilli()}
+}
+
+// IsZero reports whether t is the zero Timestamp.
+func (t Timestamp) IsZero() bool { return t == Timestamp{} }
+
+// Time returns the physical part of t.
+func (t Timestamp) Time() time.Time { return time.UnixMilli(t.Wall).UTC() }
+
+// Compare returns -1, 0 or +1 as t is older than, equal to or newer than o.
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall < o.Wall:
+		return -1
+	case t.Wall > o.Wall:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Before reports whether t is older than o.
+func (t Timestamp) Before(o Timestamp) bool { return t.Compare(o) < 0 }
+
+// After reports whether t is newer than o.
+func (t Timestamp) After(o Timestamp) bool { return t.Compare(o) > 0 }
+
+// String formats t as "<wall>.<logical>", the form used on the wire and in the database.
+func (t Timestamp) String() string {
+	return strconv.FormatInt(t.Wall, 10) + "." + strconv.FormatUint(uint64(t.Logical), 10)
+}
+
+// Parse reads a timestamp written by String.
+func Parse(s string) (Timestamp, error) {
+	wall, logical, ok := strings.Cut(s, ".")
+	if !ok {
+		return Timestamp{}, fmt.Errorf("hlc: malformed timestamp %q", s)
+	}
+	w, err1 := strconv.ParseInt(wall, 10, 64)
+	l, err2 := strconv.ParseUint(logical, 10, 32)
+	if err1 != nil || err2 != nil || w < 0 {
+		return Timestamp{}, fmt.Errorf("hlc: malformed timestamp %q", s)
+	}
+	return Timestamp{Wall: w, Logical: uint32(l)}, nil
+}
+
+// RFC3339 formats t as an RFC3339 time with nanosecond precision whose sub-millisecond digits hold
+// the logical counter. Code that parses it as a plain time still orders it correctly.
+func (t Timestamp) RFC3339() string {
+	return time.UnixMilli(t.Wall).Add(time.Duration(t.Logical)).UTC().Format(time.RFC3339Nano)
+}
+
+// ParseRFC3339 reads a timestamp written by RFC3339. Plain RFC3339 times, such as those written
+// before hybrid clocks were introduced, parse with their sub-millisecond digits as the counter.
+func ParseRFC3339(s string) (Timestamp, error) {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("hlc: %w", err)
+	}
+	ns := tm.UnixNano()
+	return Timestamp{Wall: ns / int64(time.Millisecond), Logical: uint32(ns % int64(time.Millisecond))}, nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so timestamps encode as strings in JSON and YAML.
+func (t Timestamp) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Timestamp) UnmarshalText(b []byte) error {
+	ts, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*t = ts
+	return nil
+}
+
+// Clock issues timestamps for one node.
+type Clock struct {
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+var (
+	defaultClock *Clock
+	defaultOnce  sync.Once
+)
+
+// NewClock returns a clock that refuses remote timestamps more than maxSkew ahead of the wall
+// clock. A maxSkew of zero accepts any timestamp.
+func NewClock(maxSkew time.Duration) *Clock {
+	return &Clock{maxSkew: maxSkew, now: time.Now}
+}
+
+// Default returns the process-wide clock, bounded by clock.max_skew.
+func Default() *Clock {
+	defaultOnce.Do(func() {
+		defaultClock = NewClock(MaxSkew())
+	})
+	return defaultClock
+}
+
+// MaxSkew returns clock.max_skew, how far ahead of our wall clock a remote timestamp may be.
+func MaxSkew() time.Duration {
+	if sec, ok := settings.Get("clock.max_skew").(int); ok && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return time.Minute
+}
+
+// Now returns a timestamp newer than every one this clock has issued or received.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wall := c.now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.tick(c.last.Logical)
+	}
+	return c.last
+}
+
+// Update folds a timestamp received from another node into the clock, so later calls to Now
+// order after it, and returns a fresh local timestamp. Timestamps beyond the skew bound are
+// refused with ErrClockSkew and leave the clock untouched.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if err := c.check(remote, now); err != nil {
+		return Timestamp{}, err
+	}
+	wall := now.UnixMilli()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last.Wall = remote.Wall
+		c.tick(remote.Logical)
+	case c.last.Wall > remote.Wall:
+		c.tick(c.last.Logical)
+	default:
+		c.tick(max(c.last.Logical, remote.Logical))
+	}
+	return c.last, nil
+}
+
+// tick sets the counter to one past logical, carrying into the next millisecond on overflow.
+func (c *Clock) tick(logical uint32) {
+	if logical >= maxLogical {
+		c.last = Timestamp{Wall: c.last.Wall + 1}
+		return
+	}
+	c.last.Logical = logical + 1
+}
+
+// Check reports ErrClockSkew if remote is beyond the skew bound, without updating the clock.
+func (c *Clock) Check(remote Timestamp) error {
+	return c.check(remote, c.now())
+}
+
+func (c *Clock) check(remote Timestamp, now time.Time) error {
+	if c.maxSkew <= 0 {
+		return nil
+	}
+	if ahead := remote.Time().Sub(now); ahead > c.maxSkew {
+		return fmt.Errorf("%w: %s ahead (limit %s)", ErrClockSkew, ahead.Round(time.Millisecond), c.maxSkew)
+	}
+	return nil
+}
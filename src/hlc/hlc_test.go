@@ -0,0 +1,113 @@
+package hlc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fixedClock returns a clock whose wall clock reads wall milliseconds and whose last timestamp is last.
+func fixedClock(maxSkew time.Duration, wall int64, last Timestamp) *Clock {
+	c := NewClock(maxSkew)
+	c.now = func() time.Time { return time.UnixMilli(wall) }
+	c.last = last
+	return c
+}
+
+func TestUpdate(t *testing.T) {
+	const wall = 1_000_000
+	tests := []struct {
+		name   string
+		last   Timestamp
+		remote Timestamp
+		want   Timestamp
+	}{
+		{"wall clock ahead of both", Timestamp{wall - 5, 3}, Timestamp{wall - 2, 7}, Timestamp{wall, 0}},
+		{"remote ahead", Timestamp{wall - 5, 3}, Timestamp{wall + 10, 7}, Timestamp{wall + 10, 8}},
+		{"last ahead", Timestamp{wall + 10, 3}, Timestamp{wall + 2, 7}, Timestamp{wall + 10, 4}},
+		{"same millisecond, remote counter higher", Timestamp{wall + 10, 3}, Timestamp{wall + 10, 7}, Timestamp{wall + 10, 8}},
+		{"same millisecond, own counter higher", Timestamp{wall + 10, 9}, Timestamp{wall + 10, 7}, Timestamp{wall + 10, 10}},
+		{"counter overflow carries", Timestamp{wall + 10, maxLogical}, Timestamp{wall + 10, 1}, Timestamp{wall + 11, 0}},
+		{"remote counter overflow carries", Timestamp{wall - 5, 0}, Timestamp{wall + 10, maxLogical}, Timestamp{wall + 11, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fixedClock(time.Minute, wall, tt.last)
+			got, err := c.Update(tt.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Update(%s) from %s = %s, want %s", tt.remote, tt.last, got, tt.want)
+			}
+			if !got.After(tt.remote) || !got.After(tt.last) {
+				t.Errorf("%s does not order after both %s and %s", got, tt.remote, tt.last)
+			}
+			if next := c.Now(); !next.After(got) {
+				t.Errorf("Now() = %s after Update returned %s", next, got)
+			}
+		})
+	}
+}
+
+func TestSkewBound(t *testing.T) {
+	const wall = 1_000_000
+	tests := []struct {
+		name    string
+		maxSkew time.Duration
+		remote  Timestamp
+		refused bool
+	}{
+		{"behind", time.Minute, Timestamp{Wall: wall - 3_600_000}, false},
+		{"at the bound", time.Minute, Timestamp{Wall: wall + 60_000}, false},
+		{"past the bound", time.Minute, Timestamp{Wall: wall + 60_001}, true},
+		{"no bound", 0, Timestamp{Wall: wall + 3_600_000}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := Timestamp{Wall: wall - 1, Logical: 4}
+			c := fixedClock(tt.maxSkew, wall, last)
+			if err := c.Check(tt.remote); errors.Is(err, ErrClockSkew) != tt.refused {
+				t.Errorf("Check(%s) = %v, refused want %v", tt.remote, err, tt.refused)
+			}
+			_, err := c.Update(tt.remote)
+			if errors.Is(err, ErrClockSkew) != tt.refused {
+				t.Errorf("Update(%s) = %v, refused want %v", tt.remote, err, tt.refused)
+			}
+			if tt.refused && c.last != last {
+				t.Errorf("refused timestamp moved the clock to %s", c.last)
+			}
+		})
+	}
+}
+
+func TestRFC3339RoundTrip(t *testing.T) {
+	stamps := []Timestamp{
+		{},
+		{Wall: 1_700_000_000_123},
+		{Wall: 1_700_000_000_123, Logical: 1},
+		{Wall: 1_700_000_000_123, Logical: maxLogical},
+		{Wall: 1_700_000_000_124},
+	}
+	for i, ts := range stamps {
+		got, err := ParseRFC3339(ts.RFC3339())
+		if err != nil {
+			t.Fatalf("ParseRFC3339(%q): %v", ts.RFC3339(), err)
+		}
+		if got != ts {
+			t.Errorf("%s round-tripped through %q as %s", ts, ts.RFC3339(), got)
+		}
+		if i == 0 {
+			continue
+		}
+		// Plain time parsing keeps the order.
+		prev, _ := time.Parse(time.RFC3339, stamps[i-1].RFC3339())
+		cur, _ := time.Parse(time.RFC3339, ts.RFC3339())
+		if !prev.Before(cur) {
+			t.Errorf("%q does not parse as later than %q", ts.RFC3339(), stamps[i-1].RFC3339())
+		}
+	}
+	if _, err := ParseRFC3339("yesterday"); err == nil {
+		t.Error("ParseRFC3339 accepted a malformed time")
+	}
+}
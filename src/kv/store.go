@@ -0,0 +1,453 @@
+// Package kv is a small key-value store replicated to every node, for state such as per-guild bot
+// configuration that must outlive any single node. Each key is a last-writer-wins register ordered
+// by hybrid logical clock timestamps (ties go to the higher origin NodeID), so replicas that have
+// seen the same writes agree whatever order they arrived in. Local writes are gossiped right away;
+// the Nexus anti-entropy sync repairs anything gossip missed. Each node owns the keys under its own
+// NodeID ("<node-id>/<name>", see OwnKey) and every entry is signed by the identity key its Origin
+// derives from, so a node can only write keys in its own namespace and never overwrite another's.
+// Deletes leave tombstones so an old replica cannot bring a key back; they are dropped after
+// kv.tombstone_ttl, which must outlast any partition. kv.max_keys bounds the keys, tombstones
+// included, a replica holds, and kv.max_keys_per_origin how many of them one node may own, so a
+// single writer cannot fill the store. Every replica persists its copy in the local database.
+package kv
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
+)
+
+const (
+	// maxKeyBytes and maxValueBytes bound a single entry.
+	maxKeyBytes   = 256
+	maxValueBytes = 16 << 10
+)
+
+var (
+	errBadKey       = errors.New("key must be 1-256 bytes")
+	errForeignKey   = errors.New("key is outside the writer's namespace")
+	errTooLarge     = errors.New("value too large")
+	errFull         = errors.New("replicated store is full (kv.max_keys)")
+	errOriginFull   = errors.New("writer owns too many keys (kv.max_keys_per_origin)")
+	errNoIdentity   = errors.New("no identity key to sign writes with")
+	errUnsigned     = errors.New("entry is not signed")
+	errBadSignature = errors.New("entry signature is invalid")
+	errWrongOrigin  = errors.New("entry origin does not match its signing key")
+)
+
+// Entry is one key's replicated state.
+type Entry struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	Stamp     hlc.Timestamp   `json:"stamp"`
+	Origin    string          `json:"origin"`
+	PublicKey string          `json:"public_key"`
+	Signature string          `json:"signature"`
+}
+
+// signingBytes returns the canonical form of the fields covered by an entry's signature.
+func (e Entry) signingBytes() []byte {
+	data, _ := json.Marshal([]string{e.Key, string(e.Value), fmt.Sprint(e.Deleted), e.Stamp.String(), e.Origin, e.PublicKey})
+	return data
+}
+
+// sign stamps id's public key on e and signs it.
+func (e *Entry) sign(id *types.Identity) {
+	e.PublicKey = id.PublicHex()
+	e.Signature = hex.EncodeToString(id.Sign(e.signingBytes()))
+}
+
+// verify checks that e lies in its Origin's namespace and was signed by the key Origin derives from.
+func (e Entry) verify() error {
+	if originOf(e.Key) != e.Origin {
+		return errForeignKey
+	}
+	if e.PublicKey == "" || e.Signature == "" {
+		return errUnsigned
+	}
+	pub, err := hex.DecodeString(e.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errUnsigned
+	}
+	if nodeid.FromPublicKey(pub) != e.Origin {
+		return errWrongOrigin
+	}
+	sig, err := hex.DecodeString(e.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), e.signingBytes(), sig) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// originOf returns the NodeID whose namespace key lies in.
+func originOf(key string) string {
+	origin, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return ""
+	}
+	return origin
+}
+
+// supersedes reports whether e wins over o in last-writer-wins order.
+func (e Entry) supersedes(o Entry) bool {
+	if c := e.Stamp.Compare(o.Stamp); c != 0 {
+		return c > 0
+	}
+	return e.Origin > o.Origin
+}
+
+// validate checks the size limits.
+func (e Entry) validate() error {
+	if e.Key == "" || len(e.Key) > maxKeyBytes {
+		return errBadKey
+	}
+	if len(e.Value) > maxValueBytes {
+		return errTooLarge
+	}
+	return nil
+}
+
+// Store is one replica.
+type Store struct {
+	self         string          // Origin of local writes, derived from identity
+	identity     *types.Identity // signs local writes; nil leaves the replica read-only
+	clock        *hlc.Clock
+	persist      bool
+	maxKeys      int
+	maxPerOrigin int
+	tombstoneTTL time.Duration
+
+	mu       sync.RWMutex
+	entries  map[string]Entry
+	owned    map[string]int // keys held per origin
+	watchers []watcher
+	publish  func(Entry)
+}
+
+// watcher is a change callback for keys with a prefix.
+type watcher struct {
+	prefix string
+	fn     func(Entry)
+}
+
+var (
+	store     *Store
+	storeOnce sync.Once
+)
+
+// New returns an in-memory replica whose writes are signed by id. Default returns the persistent,
+// process-wide one.
+func New(id *types.Identity, clock *hlc.Clock) *Store {
+	s := &Store{
+		identity:     id,
+		clock:        clock,
+		maxKeys:      intSetting("kv.max_keys", 10000),
+		maxPerOrigin: intSetting("kv.max_keys_per_origin", 1000),
+		tombstoneTTL: time.Duration(intSetting("kv.tombstone_ttl", 7*24*3600)) * time.Second,
+		entries:      make(map[string]Entry),
+		owned:        make(map[string]int),
+	}
+	if id != nil {
+		s.self = nodeid.FromPublicKey(id.Public)
+	}
+	return s
+}
+
+func intSetting(key string, def int) int {
+	if n, ok := settings.Get(key).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
+
+// Default returns the process-wide replica, loading it from the database on first use. Stored
+// entries that no longer verify are dropped.
+func Default() *Store {
+	storeOnce.Do(func() {
+		store = New(types.LocalIdentity(), hlc.Default())
+		if _, err := db.Open(); err != nil {
+			logger.Log("ERROR", "kv", "Replicated store running in memory only: "+err.Error())
+			return
+		}
+		store.persist = true
+		var invalid []string
+		err := db.ForEach(db.BucketKV, func(key string, value []byte) error {
+			var e Entry
+			if err := json.Unmarshal(value, &e); err != nil || e.Key != key || e.verify() != nil {
+				invalid = append(invalid, key)
+				return nil
+			}
+			store.entries[key] = e
+			store.owned[e.Origin]++
+			return nil
+		})
+		if err != nil {
+			logger.Log("ERROR", "kv", "Failed to load replicated store: "+err.Error())
+		}
+		for _, key := range invalid {
+			_ = db.Delete(db.BucketKV, key)
+		}
+		if len(invalid) > 0 {
+			logger.Log("WARN", "kv", fmt.Sprintf("Dropped %d stored keys without a valid signature", len(invalid)))
+		}
+		store.Collect()
+		logger.Log("DEBUG", "kv", fmt.Sprintf("Loaded %d replicated keys", len(store.entries)))
+	})
+	return store
+}
+
+// OwnKey returns the key name has in this node's namespace, the only keys it may write.
+func (s *Store) OwnKey(name string) string {
+	return s.self + "/" + name
+}
+
+// Get returns the value of key.
+func (s *Store) Get(key string) (json.RawMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// GetJSON decodes the value of key into v and reports whether the key exists.
+func (s *Store) GetJSON(key string, v any) (bool, error) {
+	raw, ok := s.Get(key)
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Keys returns the live keys starting with prefix, sorted.
+func (s *Store) Keys(prefix string) []string {
+	s.mu.RLock()
+	var out []string
+	for k, e := range s.entries {
+		if !e.Deleted && strings.HasPrefix(k, prefix) {
+			out = append(out, k)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(out)
+	return out
+}
+
+// Set stores v, encoded as JSON, under key and replicates it. key must be one of ours (see OwnKey).
+func (s *Store) Set(key string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(Entry{Key: key, Value: value})
+}
+
+// Delete removes key, one of ours, everywhere.
+func (s *Store) Delete(key string) error {
+	return s.write(Entry{Key: key, Deleted: true})
+}
+
+// write stamps and signs a local change, applies it and hands it to gossip.
+func (s *Store) write(e Entry) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	if s.identity == nil {
+		return errNoIdentity
+	}
+	if originOf(e.Key) != s.self {
+		return errForeignKey
+	}
+	e.Stamp = s.clock.Now()
+	e.Origin = s.self
+	e.sign(s.identity)
+
+	s.mu.Lock()
+	if err := s.hasRoomLocked(e); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.apply(e)
+	publish := s.publish
+	s.mu.Unlock()
+
+	s.notify([]Entry{e})
+	if publish != nil {
+		publish(e)
+	}
+	return nil
+}
+
+// Merge folds entries received from other replicas in and returns those that changed our state.
+// Invalid or badly signed entries, entries outside their writer's namespace, entries stamped beyond
+// the clock's skew bound and new keys that do not fit under kv.max_keys or kv.max_keys_per_origin
+// are dropped. A tombstone older than kv.tombstone_ttl still deletes
+// an older value but is not kept.
+func (s *Store) Merge(entries []Entry) []Entry {
+	var changed []Entry
+	expired := s.tombstoneCutoff()
+	s.mu.Lock()
+	for _, e := range entries {
+		if err := e.validate(); err != nil {
+			logger.Log("DEBUG", "kv", fmt.Sprintf("Dropping entry from %s: %v", e.Origin, err))
+			continue
+		}
+		if err := e.verify(); err != nil {
+			logger.Log("WARN", "kv", fmt.Sprintf("Dropping %q claiming origin %s: %v", e.Key, e.Origin, err))
+			continue
+		}
+		if _, err := s.clock.Update(e.Stamp); err != nil {
+			logger.Log("WARN", "kv", fmt.Sprintf("Dropping %q from %s: %v", e.Key, e.Origin, err))
+			continue
+		}
+		cur, ok := s.entries[e.Key]
+		if ok && !e.supersedes(cur) {
+			continue
+		}
+		if e.Deleted && e.Stamp.Before(expired) {
+			if ok {
+				s.remove(e.Key)
+				changed = append(changed, e)
+			}
+			continue
+		}
+		if err := s.hasRoomLocked(e); err != nil {
+			logger.Log("WARN", "kv", fmt.Sprintf("Dropping %q from %s: %v", e.Key, e.Origin, err))
+			continue
+		}
+		s.apply(e)
+		changed = append(changed, e)
+	}
+	s.mu.Unlock()
+
+	s.notify(changed)
+	return changed
+}
+
+// Entries returns every entry, tombstones included.
+func (s *Store) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, e)
+	}
+	return out
+}
+
+// Watch calls fn for every change, local or remote, to a key starting with prefix.
+func (s *Store) Watch(prefix string, fn func(Entry)) {
+	s.mu.Lock()
+	s.watchers = append(s.watchers, watcher{prefix, fn})
+	s.mu.Unlock()
+}
+
+// Collect drops tombstones older than kv.tombstone_ttl and returns how many it dropped.
+func (s *Store) Collect() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collectLocked()
+}
+
+func (s *Store) collectLocked() int {
+	cutoff := s.tombstoneCutoff()
+	dropped := 0
+	for key, e := range s.entries {
+		if e.Deleted && e.Stamp.Before(cutoff) {
+			s.remove(key)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		logger.Log("DEBUG", "kv", fmt.Sprintf("Dropped %d expired tombstones", dropped))
+	}
+	return dropped
+}
+
+// tombstoneCutoff is the stamp before which tombstones have expired.
+func (s *Store) tombstoneCutoff() hlc.Timestamp {
+	return hlc.FromTime(time.Now().Add(-s.tombstoneTTL))
+}
+
+// hasRoomLocked reports why e's key, if new, does not fit under kv.max_keys and its origin's
+// kv.max_keys_per_origin, dropping expired tombstones to make room if needed. Call with s.mu held.
+func (s *Store) hasRoomLocked(e Entry) error {
+	if _, ok := s.entries[e.Key]; ok {
+		return nil
+	}
+	if len(s.entries) >= s.maxKeys || s.owned[e.Origin] >= s.maxPerOrigin {
+		s.collectLocked()
+	}
+	if len(s.entries) >= s.maxKeys {
+		return errFull
+	}
+	if s.owned[e.Origin] >= s.maxPerOrigin {
+		return errOriginFull
+	}
+	return nil
+}
+
+// remove forgets key. Call with s.mu held.
+func (s *Store) remove(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	if s.owned[e.Origin]--; s.owned[e.Origin] <= 0 {
+		delete(s.owned, e.Origin)
+	}
+	if !s.persist {
+		return
+	}
+	if err := db.Delete(db.BucketKV, key); err != nil {
+		logger.Log("ERROR", "kv", fmt.Sprintf("Failed to delete %q: %v", key, err))
+	}
+}
+
+// apply stores e and persists it. Call with s.mu held.
+func (s *Store) apply(e Entry) {
+	if _, ok := s.entries[e.Key]; !ok {
+		s.owned[e.Origin]++
+	}
+	s.entries[e.Key] = e
+	if !s.persist {
+		return
+	}
+	if err := db.PutJSON(db.BucketKV, e.Key, e); err != nil {
+		logger.Log("ERROR", "kv", fmt.Sprintf("Failed to save %q: %v", e.Key, err))
+	}
+}
+
+// notify runs the watchers for each changed entry.
+func (s *Store) notify(changed []Entry) {
+	if len(changed) == 0 {
+		return
+	}
+	s.mu.RLock()
+	watchers := append([]watcher(nil), s.watchers...)
+	s.mu.RUnlock()
+	for _, e := range changed {
+		for _, w := range watchers {
+			if strings.HasPrefix(e.Key, w.prefix) {
+				w.fn(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/types"
+)
+
+func newReplica(t *testing.T) *Store {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(&types.Identity{Private: priv, Public: pub}, hlc.NewClock(time.Minute))
+}
+
+func TestMergeVerifiesSignatures(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	key := a.OwnKey("guild.1")
+	if err := a.Set(key, "on"); err != nil {
+		t.Fatal(err)
+	}
+	genuine := a.Entries()[0]
+
+	tampered := genuine
+	tampered.Value = json.RawMessage(`"off"`)
+	impersonated := genuine
+	impersonated.Origin = b.self
+	unsigned := genuine
+	unsigned.Signature = ""
+
+	if got := b.Merge([]Entry{tampered, impersonated, unsigned}); len(got) != 0 {
+		t.Errorf("merged %d forged entries", len(got))
+	}
+	if _, ok := b.Get(key); ok {
+		t.Fatal("forged entry stored")
+	}
+	if got := b.Merge([]Entry{genuine}); len(got) != 1 {
+		t.Fatalf("genuine entry not merged")
+	}
+	var v string
+	if ok, err := b.GetJSON(key, &v); !ok || err != nil || v != "on" {
+		t.Errorf("after merge got %q, %v, %v", v, ok, err)
+	}
+}
+
+func TestStoreIsBounded(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	a.maxKeys, b.maxKeys = 3, 2
+	for i := 0; i < 3; i++ {
+		if err := a.Set(a.OwnKey(fmt.Sprintf("k%d", i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Set(a.OwnKey("k3"), 3); !errors.Is(err, errFull) {
+		t.Errorf("write past max_keys: %v, want errFull", err)
+	}
+	if err := a.Set(a.OwnKey("k0"), "updated"); err != nil {
+		t.Errorf("update of an existing key refused: %v", err)
+	}
+	b.Merge(a.Entries())
+	if n := len(b.Entries()); n != 2 {
+		t.Errorf("replica holds %d keys, max_keys is 2", n)
+	}
+}
+
+func TestExpiredTombstonesAreCollected(t *testing.T) {
+	a, b := newReplica(t), newReplica(t)
+	a.tombstoneTTL, b.tombstoneTTL = 50*time.Millisecond, 50*time.Millisecond
+	if err := a.Set(a.OwnKey("gone"), 1); err != nil {
+		t.Fatal(err)
+	}
+	b.Merge(a.Entries())
+	if err := a.Delete(a.OwnKey("gone")); err != nil {
+		t.Fatal(err)
+	}
+	tombstone := a.Entries()[0]
+
+	time.Sleep(100 * time.Millisecond)
+	if n := a.Collect(); n != 1 || len(a.Entries()) != 0 {
+		t.Errorf("collected %d tombstones, %d entries left", n, len(a.Entries()))
+	}
+	// An expired tombstone still deletes the value it supersedes, but is not kept.
+	b.Merge([]Entry{tombstone})
+	if n := len(b.Entries()); n != 0 {
+		t.Errorf("replica kept %d entries after an expired delete", n)
+	}
+}
+
+func TestWritersKeepToTheirNamespace(t *testing.T) {
+	a, b, c := newReplica(t), newReplica(t), newReplica(t)
+	if err := a.Set(b.OwnKey("guild.1"), "mine"); !errors.Is(err, errForeignKey) {
+		t.Errorf("write into another node's namespace: %v, want errForeignKey", err)
+	}
+	if err := b.Set(b.OwnKey("guild.1"), "on"); err != nil {
+		t.Fatal(err)
+	}
+	c.Merge(b.Entries())
+
+	// A correctly signed entry from a that names b's key is still refused.
+	forged := Entry{Key: b.OwnKey("guild.1"), Value: json.RawMessage(`"off"`), Stamp: a.clock.Now(), Origin: a.self}
+	forged.sign(a.identity)
+	if got := c.Merge([]Entry{forged}); len(got) != 0 {
+		t.Errorf("merged an overwrite of another node's key")
+	}
+	var v string
+	if ok, _ := c.GetJSON(b.OwnKey("guild.1"), &v); !ok || v != "on" {
+		t.Errorf("b's key is %q, want on", v)
+	}
+
+	// One writer cannot take more than its share of the store.
+	a.maxPerOrigin, c.maxPerOrigin = 2, 2
+	for i := 0; i < 2; i++ {
+		if err := a.Set(a.OwnKey(fmt.Sprintf("k%d", i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Set(a.OwnKey("k2"), 2); !errors.Is(err, errOriginFull) {
+		t.Errorf("write past max_keys_per_origin: %v, want errOriginFull", err)
+	}
+	flood := newReplica(t)
+	for i := 0; i < 5; i++ {
+		if err := flood.Set(flood.OwnKey(fmt.Sprintf("k%d", i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.Merge(flood.Entries()); len(got) != 2 {
+		t.Errorf("merged %d keys from one writer, max_keys_per_origin is 2", len(got))
+	}
+}
+
+// syncFrom runs one KV sync with init as the initiator and resp as the responder, passing the
+// deltas as p2p does on a v8 session.
+func syncFrom(init, resp *Store) {
+	diff := DiffBuckets(resp.Digest(), init.Digest())
+	if len(diff) == 0 {
+		return
+	}
+	first := resp.EntriesIn(diff)
+	reply := init.NewerThan(diff, VersionsOf(first))
+	init.Merge(first)
+	have := init.VersionsIn(diff)
+	resp.Merge(reply)
+	init.Merge(resp.NewerThan(diff, have))
+}
+
+func TestInitiatorCatchesUpOnLargeStore(t *testing.T) {
+	big, fresh := newReplica(t), newReplica(t)
+	keys := 3*MaxDeltaEntries + 17
+	for i := 0; i < keys; i++ {
+		if err := big.Set(big.OwnKey(fmt.Sprintf("k%d", i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fresh node only ever initiates, as a node behind NAT would.
+	for round := 1; len(fresh.Entries()) < keys; round++ {
+		before := len(fresh.Entries())
+		syncFrom(fresh, big)
+		if len(fresh.Entries()) == before {
+			t.Fatalf("sync %d made no progress at %d of %d keys", round, before, keys)
+		}
+	}
+	if big.Digest().Root != fresh.Digest().Root {
+		t.Errorf("replicas differ after catching up")
+	}
+}
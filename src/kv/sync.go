@@ -0,0 +1,202 @@
+package kv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"atsuko-nexus/src/gossip"
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/lifecycle"
+	"atsuko-nexus/src/logger"
+)
+
+// Topic is the gossip topic local writes travel on.
+const Topic = "kv.entry"
+
+const (
+	// DigestBuckets is how many buckets the anti-entropy digest splits the keys into.
+	DigestBuckets = 64
+	// MaxDeltaEntries caps the entries sent in one sync message; the rest follow in later syncs.
+	MaxDeltaEntries = 200
+)
+
+// Version names the state of one key without its value, so a peer can tell what we already hold.
+type Version struct {
+	Key   string        `json:"key"`
+	Stamp hlc.Timestamp `json:"stamp"`
+}
+
+// Digest summarises a replica: a hash per bucket of keys and a root hash over the buckets.
+type Digest struct {
+	Root    string   `json:"root"`
+	Buckets []string `json:"buckets"`
+}
+
+// bucketOf maps a key to its digest bucket.
+func bucketOf(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return int(sum[0]) % DigestBuckets
+}
+
+// Digest hashes each bucket's entries, sorted by key, and then the bucket hashes.
+func (s *Store) Digest() Digest {
+	var grouped [DigestBuckets][]Entry
+	for _, e := range s.Entries() {
+		b := bucketOf(e.Key)
+		grouped[b] = append(grouped[b], e)
+	}
+
+	root := sha256.New()
+	d := Digest{Buckets: make([]string, DigestBuckets)}
+	for i, bucket := range grouped {
+		sort.Slice(bucket, func(a, b int) bool { return bucket[a].Key < bucket[b].Key })
+		h := sha256.New()
+		for _, e := range bucket {
+			h.Write([]byte(e.Key + "\x00" + e.Stamp.String() + "\x00" + e.Origin + "\x00" + strconv.FormatBool(e.Deleted) + "\n"))
+		}
+		d.Buckets[i] = hex.EncodeToString(h.Sum(nil))
+		root.Write([]byte(d.Buckets[i]))
+	}
+	d.Root = hex.EncodeToString(root.Sum(nil))
+	return d
+}
+
+// Valid reports whether d has the expected shape.
+func (d Digest) Valid() bool {
+	return len(d.Buckets) == DigestBuckets
+}
+
+// DiffBuckets lists the buckets whose hashes differ between a and b.
+func DiffBuckets(a, b Digest) []int {
+	var out []int
+	for i := range a.Buckets {
+		if i >= len(b.Buckets) || a.Buckets[i] != b.Buckets[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// ValidBuckets reports whether every index names a digest bucket.
+func ValidBuckets(buckets []int) bool {
+	for _, b := range buckets {
+		if b < 0 || b >= DigestBuckets {
+			return false
+		}
+	}
+	return true
+}
+
+// EntriesIn returns our entries that fall in buckets, at most MaxDeltaEntries of them.
+func (s *Store) EntriesIn(buckets []int) []Entry {
+	return capEntries(inBuckets(s.Entries(), buckets))
+}
+
+// VersionsIn returns the version of each of our keys that falls in buckets.
+func (s *Store) VersionsIn(buckets []int) []Version {
+	return VersionsOf(inBuckets(s.Entries(), buckets))
+}
+
+// VersionsOf returns the versions of entries.
+func VersionsOf(entries []Entry) []Version {
+	out := make([]Version, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, Version{Key: e.Key, Stamp: e.Stamp})
+	}
+	return out
+}
+
+// NewerThan returns our entries in buckets that theirs lacks or holds an older version of, at most
+// MaxDeltaEntries of them. Since theirs names every key the peer holds in buckets, repeated calls
+// page through everything the peer is missing rather than resending what it already has.
+func (s *Store) NewerThan(buckets []int, theirs []Version) []Entry {
+	have := make(map[string]Entry, len(theirs))
+	for _, v := range theirs {
+		have[v.Key] = Entry{Key: v.Key, Stamp: v.Stamp, Origin: originOf(v.Key)}
+	}
+	var out []Entry
+	for _, e := range inBuckets(s.Entries(), buckets) {
+		if t, ok := have[e.Key]; !ok || e.supersedes(t) {
+			out = append(out, e)
+		}
+	}
+	return capEntries(out)
+}
+
+// inBuckets keeps the entries whose keys fall in buckets.
+func inBuckets(entries []Entry, buckets []int) []Entry {
+	want := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		want[b] = true
+	}
+	var out []Entry
+	for _, e := range entries {
+		if want[bucketOf(e.Key)] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// capEntries trims entries to MaxDeltaEntries, keeping the newest.
+func capEntries(entries []Entry) []Entry {
+	if len(entries) <= MaxDeltaEntries {
+		return entries
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].supersedes(entries[j]) })
+	return entries[:MaxDeltaEntries]
+}
+
+// Service gossips local writes of the process-wide replica and merges writes gossiped by others.
+func Service() lifecycle.Service {
+	return lifecycle.Func("kv store", func(ctx context.Context) error {
+		node := gossip.Default()
+		if node == nil {
+			return errors.New("gossip layer not started")
+		}
+		s := Default()
+		// Refuse forged entries before the gossip layer forwards them.
+		node.Validate(Topic, func(msg gossip.Message) error {
+			var e Entry
+			if err := json.Unmarshal(msg.Payload, &e); err != nil {
+				return err
+			}
+			if err := e.validate(); err != nil {
+				return err
+			}
+			return e.verify()
+		})
+		gossip.On(node, Topic, func(from string, e Entry) {
+			if from != node.Self() {
+				s.Merge([]Entry{e})
+			}
+		})
+		s.mu.Lock()
+		s.publish = func(e Entry) {
+			if _, err := node.Publish(Topic, e); err != nil {
+				logger.Log("ERROR", "kv", fmt.Sprintf("Failed to gossip %q: %v", e.Key, err))
+			}
+		}
+		s.mu.Unlock()
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					s.Collect()
+				}
+			}
+		}()
+		return nil
+	}, nil)
+}
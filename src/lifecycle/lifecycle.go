@@ -0,0 +1,175 @@
+// Package lifecycle starts the node's background subsystems and shuts them down in order.
+// Every subsystem implements Service; the Manager stops them in reverse start order under one bounded timeout.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/logger"
+)
+
+// Service is a background subsystem with an explicit start and stop.
+// Start must not block; long-running work belongs in goroutines that watch ctx.
+// Stop must return once the subsystem has finished or ctx expires.
+type Service interface {
+	Name() string
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Manager owns the running services.
+type Manager struct {
+	mu      sync.Mutex
+	running []Service
+	stopped bool
+}
+
+// New returns an empty Manager.
+func New() *Manager {
+	return &Manager{}
+}
+
+// Start starts each service in order and remembers it for Shutdown.
+// If one fails to start, the error is returned and the remaining services are not started.
+func (m *Manager) Start(ctx context.Context, services ...Service) error {
+	for _, s := range services {
+		m.mu.Lock()
+		if m.stopped {
+			m.mu.Unlock()
+			return fmt.Errorf("cannot start %s: shutdown in progress", s.Name())
+		}
+		m.mu.Unlock()
+
+		logger.Log("DEBUG", "lifecycle", "Starting "+s.Name())
+		if err := s.Start(ctx); err != nil {
+			return fmt.Errorf("start %s: %w", s.Name(), err)
+		}
+
+		m.mu.Lock()
+		m.running = append(m.running, s)
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Shutdown stops every started service in reverse order. The whole shutdown is bounded by timeout;
+// services still running when it expires are abandoned and reported in the returned error.
+func (m *Manager) Shutdown(timeout time.Duration) error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	services := m.running
+	m.running = nil
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logger.Log("INFO", "lifecycle", fmt.Sprintf("Shutting down %d services (timeout %s)", len(services), timeout))
+	var errs []error
+	for i := len(services) - 1; i >= 0; i-- {
+		s := services[i]
+		start := time.Now()
+		if err := s.Stop(ctx); err != nil {
+			logger.Log("ERROR", "lifecycle", fmt.Sprintf("Stopping %s failed: %v", s.Name(), err))
+			errs = append(errs, fmt.Errorf("stop %s: %w", s.Name(), err))
+			continue
+		}
+		logger.Log("DEBUG", "lifecycle", fmt.Sprintf("Stopped %s in %s", s.Name(), time.Since(start).Round(time.Millisecond)))
+	}
+	return errors.Join(errs...)
+}
+
+// loop runs fn on a fixed schedule until stopped.
+type loop struct {
+	name      string
+	interval  func() time.Duration
+	immediate bool
+	fn        func(ctx context.Context)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Loop returns a Service that calls fn every interval() until stopped. When immediate is true the
+// first call happens right away instead of after one interval. fn receives a context that is
+// cancelled on Stop and should return promptly once it is.
+func Loop(name string, interval func() time.Duration, immediate bool, fn func(ctx context.Context)) Service {
+	return &loop{name: name, interval: interval, immediate: immediate, fn: fn}
+}
+
+// Every is Loop with a constant interval.
+func Every(name string, interval time.Duration, immediate bool, fn func(ctx context.Context)) Service {
+	return Loop(name, func() time.Duration { return interval }, immediate, fn)
+}
+
+func (l *loop) Name() string { return l.name }
+
+func (l *loop) Start(ctx context.Context) error {
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		if l.immediate {
+			l.fn(ctx)
+		}
+		for {
+			timer := time.NewTimer(l.interval())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				l.fn(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *loop) Stop(ctx context.Context) error {
+	if l.cancel == nil {
+		return nil
+	}
+	l.cancel()
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Func adapts a pair of plain functions into a Service; either may be nil.
+func Func(name string, start func(ctx context.Context) error, stop func(ctx context.Context) error) Service {
+	return &funcService{name: name, start: start, stop: stop}
+}
+
+type funcService struct {
+	name  string
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func (f *funcService) Name() string { return f.name }
+
+func (f *funcService) Start(ctx context.Context) error {
+	if f.start == nil {
+		return nil
+	}
+	return f.start(ctx)
+}
+
+func (f *funcService) Stop(ctx context.Context) error {
+	if f.stop == nil {
+		return nil
+	}
+	return f.stop(ctx)
+}
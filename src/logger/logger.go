@@ -5,6 +5,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -39,6 +40,16 @@ var (
 		"SETTINGS":  lipgloss.NewStyle().Foreground(lipgloss.Color("#40E0D0")),
         "TAPSYNC":  lipgloss.NewStyle().Foreground(lipgloss.Color("#55d3e7")),
         "UI": lipgloss.NewStyle().Foreground(lipgloss.Color("#6937a3")),
+        "IDENTITY": lipgloss.NewStyle().Foreground(lipgloss.Color("#E0B0FF")),
+        "DHT": lipgloss.NewStyle().Foreground(lipgloss.Color("#87CEFA")),
+        "LAN": lipgloss.NewStyle().Foreground(lipgloss.Color("#98FB98")),
+        "LIFECYCLE": lipgloss.NewStyle().Foreground(lipgloss.Color("#B0C4DE")),
+        "DB": lipgloss.NewStyle().Foreground(lipgloss.Color("#F4A460")),
+        "GOSSIP": lipgloss.NewStyle().Foreground(lipgloss.Color("#FFDAB9")),
+        "ADMIN": lipgloss.NewStyle().Foreground(lipgloss.Color("#FF6347")),
+        "TASKS": lipgloss.NewStyle().Foreground(lipgloss.Color("#DDA0DD")),
+        "ELECTION": lipgloss.NewStyle().Foreground(lipgloss.Color("#F0E68C")),
+        "KV": lipgloss.NewStyle().Foreground(lipgloss.Color("#AFEEEE")),
 	}
 
 	// Config options
@@ -56,6 +67,9 @@ var (
 	maxLogSizeMB  = 10
 	maxLogAgeDays = 7
 	logFileHandle *os.File
+
+	// console receives plain log lines in headless mode; nil while the TUI owns the terminal.
+	console io.Writer
 )
 
 func init() {
@@ -167,11 +181,48 @@ func Log(level string, typ string, message string) {
 		logs = logs[1:]
 	}
 
-	if logToFile && logFileHandle != nil {
+	if (logToFile && logFileHandle != nil) || console != nil {
 		plain := fmt.Sprintf("%s | %-6s | %-8s | %s\n",
 			time.Now().Format("2006-01-02 15:04:05"), upperLevel, upperTyp, message)
-		_, _ = logFileHandle.WriteString(plain)
+		if logToFile && logFileHandle != nil {
+			_, _ = logFileHandle.WriteString(plain)
+		}
+		if console != nil {
+			_, _ = io.WriteString(console, plain)
+		}
+	}
+}
+
+// SetConsole mirrors every log entry as a plain, uncoloured line to w (e.g. os.Stdout in headless mode).
+// Pass nil to stop.
+func SetConsole(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	console = w
+}
+
+// Close flushes and closes the log file, if one is open. Later entries are kept in memory only.
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if logFileHandle != nil {
+		_ = logFileHandle.Sync()
+		_ = logFileHandle.Close()
+		logFileHandle = nil
+	}
+}
+
+// SetLogFile switches file logging on and points it at path, overriding logger.log_file_path.
+func SetLogFile(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if logFileHandle != nil {
+		_ = logFileHandle.Close()
+		logFileHandle = nil
 	}
+	logFilePath = path
+	logToFile = true
+	setupLogFile()
 }
 
 func isLevelEnabled(level string) bool {
@@ -1,52 +1,197 @@
 // Package main is the entry point for the Atsuko Nexus application.
-// It initializes logging, node identification, starts a periodic updater, and launches the user interface.
+// It initializes logging, node identification, starts the background services under a lifecycle manager, and launches
+// the user interface, or runs as a headless daemon with --headless / "daemon".
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"atsuko-nexus/src/admin"
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/heartbeat"
+	"atsuko-nexus/src/kv"
+	"atsuko-nexus/src/lifecycle"
 	"atsuko-nexus/src/logger"
 	"atsuko-nexus/src/nodeid"
 	"atsuko-nexus/src/p2p"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/tasks"
 	"atsuko-nexus/src/ui"
 	"atsuko-nexus/src/updater"
-	"time"
 
 	"atsuko-nexus/src/types"
 )
 
+// shutdownTimeout bounds how long all services together may take to stop.
+const shutdownTimeout = 15 * time.Second
+
+// peerFlags collects every --peer flag given on the command line.
+type peerFlags []string
+
+func (p *peerFlags) String() string { return strings.Join(*p, ",") }
+
+func (p *peerFlags) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
 // main initializes the node and begins execution.
-// It starts the updater in a separate goroutine to run every 10 minutes while the main thread runs the interactive user interface.
+// Background work (listener, LAN discovery, updater, TapSync, DHT refresh, heartbeat) runs as lifecycle services
+// while the main thread runs the interactive user interface or waits for a signal in headless mode.
+// On exit every service is stopped in reverse order within shutdownTimeout.
 func main() {
+	var seedPeers peerFlags
+	flag.Var(&seedPeers, "peer", "bootstrap peer in IP:PORT format (repeatable)")
+	headless := flag.Bool("headless", false, "run without the terminal UI and log plain lines to stdout")
+	logFile := flag.String("log-file", "", "in headless mode, write logs to this file instead of stdout")
+	flag.Parse()
+	// "atsuko admin ..." signs a network command, hands it to the local node and exits
+	if flag.Arg(0) == "admin" {
+		if err := admin.RunCLI(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "admin:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// "atsuko daemon" is an alias for --headless
+	if flag.Arg(0) == "daemon" {
+		*headless = true
+	}
+	if *headless {
+		if *logFile != "" {
+			logger.SetLogFile(*logFile)
+		} else {
+			logger.SetConsole(os.Stdout)
+		}
+	}
+	defer logger.Close()
+
+	// Cancelled on SIGINT/SIGTERM, or when the updater asks for a restart
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	//Commented to prevent the node from generating new keys, just left incase admin needs new keys
 	//types.GenKey()
 	role := types.NodeType()
-	// Generate a unique Node ID based on system-specific data
+	// Load or create the Ed25519 identity used to sign our peer record
+	identity := types.LocalIdentity()
+	if identity == nil && nodeid.Mode() == nodeid.ModeKey {
+		// Our NodeID is derived from this key; carrying on would silently give us a new identity
+		logger.Log("ERROR", "MAIN", "No usable identity key; refusing to start. Fix or remove the key file and restart.")
+		return
+	}
+	// Derive the Node ID from the identity key (or the hardware fingerprint in legacy mode)
 	nodeID := nodeid.GetNodeID()
 
 	// Log the startup event with the generated Node ID
 	logger.Log("INFO", "MAIN", "Script started with ID: "+nodeID)
 	logger.Log("INFO", "MAIN", "Role is: "+role)
+	if identity != nil {
+		logger.Log("INFO", "MAIN", "Identity public key: "+identity.PublicHex())
+	}
 
-	// Start Bootstrap Listener
-	p2p.StartNexusListener()
+	services := lifecycle.New()
+	defer func() {
+		if err := services.Shutdown(shutdownTimeout); err != nil {
+			logger.Log("ERROR", "MAIN", "Unclean shutdown: "+err.Error())
+		} else {
+			logger.Log("INFO", "MAIN", "Shutdown complete.")
+		}
+	}()
+
+	// Stopped last: flush the peer cache, remove the UPnP mapping and close the database once nothing else is running
+	err := services.Start(ctx,
+		lifecycle.Func("database", func(context.Context) error {
+			if _, err := db.Open(); err != nil {
+				return err
+			}
+			if err := p2p.NoteMigration(db.GetMeta("node_id"), nodeID); err != nil {
+				return err
+			}
+			if err := db.SetMeta("node_id", nodeID); err != nil {
+				return err
+			}
+			return db.SetMeta("last_started", time.Now().UTC().Format(time.RFC3339))
+		}, func(context.Context) error { return db.Close() }),
+		lifecycle.Func("upnp", nil, func(context.Context) error { return p2p.ReleaseUPnP() }),
+		lifecycle.Func("peer cache", nil, func(context.Context) error { return p2p.FlushPeers() }),
+		// Start Bootstrap Listener
+		p2p.NewNexusListener(),
+		// Announce ourselves and listen for other nodes on the local network
+		p2p.NewLANDiscovery(),
+	)
+	if err != nil {
+		logger.Log("ERROR", "MAIN", "Startup failed: "+err.Error())
+		return
+	}
 
 	// Start Bootstrap
-	p2p.Bootstrap()
+	p2p.Bootstrap(ctx, seedPeers, !*headless)
 
-	// Start the updater in a background goroutine to run every 5 minutes
-	go func() {
-		for {
+	err = services.Start(ctx,
+		// Check for updates every 5 minutes; a successful update shuts the node down for a restart
+		lifecycle.Every("updater", 5*time.Minute, true, func(ctx context.Context) {
 			logger.Log("DEBUG", "UPDATER", "Running updater check")
-			updater.RunUpdater()
-			time.Sleep(5 * time.Minute)
-		}
-	}()
-	go func() {
-		for {
-			p2p.TapSync()
-			time.Sleep(1 * time.Minute)
-		}
-	}()
+			if updater.RunUpdater() {
+				stop()
+			}
+		}),
+		lifecycle.Every("tapsync", 1*time.Minute, true, p2p.TapSync),
+		// Walk the DHT on the configured discovery interval to keep the routing table fresh
+		lifecycle.Every("dht refresh", discoveryInterval(), false, func(context.Context) {
+			p2p.DiscoverPeers()
+		}),
+		// Forget rate-limit buckets for peers that have gone quiet
+		lifecycle.Every("rate limiter prune", 1*time.Minute, false, func(context.Context) {
+			p2p.Limiter().Prune()
+		}),
+		// Spread broadcast messages and periodically pull any we missed
+		p2p.NewGossip(),
+		// Replicate the shared key-value store: gossip local writes and merge everyone else's
+		kv.Service(),
+		// Verify and apply admin-signed commands; an announced minimum version above ours triggers an update
+		admin.Service(func() {
+			if updater.RunUpdater() {
+				stop()
+			}
+		}),
+		// Agree with the other eligible nodes on a single leader per scope
+		p2p.NewElection(),
+		// Run queued background jobs unless an admin has paused task processing; idle nodes take
+		// surplus work from busy peers
+		tasks.Service(admin.TasksPaused, p2p.TaskRemote()),
+		// Log a heartbeat with system metrics on the configured interval
+		heartbeat.Service(),
+	)
+	if err != nil {
+		logger.Log("ERROR", "MAIN", "Startup failed: "+err.Error())
+		return
+	}
+
+	if *headless {
+		// Block until the service manager or user asks us to stop.
+		logger.Log("INFO", "MAIN", "Running headless; send SIGINT or SIGTERM to stop.")
+		<-ctx.Done()
+		logger.Log("INFO", "MAIN", "Stop requested, shutting down.")
+		return
+	}
+
 	// Start the terminal user interface.
-	// This call blocks the main thread until the UI exits.
-	ui.Start(nodeID)
+	// This call blocks the main thread until the UI exits or ctx is cancelled.
+	ui.Start(ctx, nodeID)
+}
+
+// discoveryInterval returns network.peer_discovery_interval, defaulting to one minute.
+func discoveryInterval() time.Duration {
+	if sec, ok := settings.Get("network.peer_discovery_interval").(int); ok && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 60 * time.Second
 }
@@ -1,5 +1,7 @@
-// Package nodeid provides a platform-independent method for generating a unique and consistent Node ID based on system-specific identifiers.
-// This ID is hashed to ensure privacy and uniformity across platforms.
+// Package nodeid provides the node's identifier.
+// By default the Node ID is the SHA-256 hash of the node's persistent identity public key, which makes it unique per
+// install, provable in a handshake and portable between machines. The older hardware fingerprint is still available
+// as an opt-in legacy mode through identity.node_id_mode.
 package nodeid
 
 import (
@@ -8,15 +10,77 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
 
 	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
 )
 
-// GetNodeID generates a unique, deterministic identifier for the current machine.
-// It collects OS-specific identifiers, joins them into a fingerprint, and returns a SHA-256 hash.
-// This ID can be used to uniquely identify a node in a distributed system.
+// Supported values for identity.node_id_mode.
+const (
+	ModeKey      = "key"
+	ModeHardware = "hardware"
+)
+
+var (
+	cachedID   string
+	cachedOnce sync.Once
+)
+
+// GetNodeID returns the identifier for the current node, computing it once per process.
+// In key mode (the default) it is derived from the identity public key; in hardware mode it is the legacy machine fingerprint.
 func GetNodeID() string {
-	logger.Log("DEBUG", "NODEID", "Getting NodeID...")
+	cachedOnce.Do(func() {
+		if Mode() == ModeHardware {
+			cachedID = HardwareID()
+			logger.Log("DEBUG", "NODEID", "Using hardware NodeID: "+cachedID)
+			return
+		}
+		id := types.LocalIdentity()
+		if id == nil {
+			logger.Log("ERROR", "NODEID", "No identity key available; falling back to hardware NodeID")
+			cachedID = HardwareID()
+			return
+		}
+		cachedID = FromPublicKey(id.Public)
+		logger.Log("DEBUG", "NODEID", "Using key-derived NodeID: "+cachedID)
+	})
+	return cachedID
+}
+
+// Mode returns the configured identity.node_id_mode, defaulting to key mode.
+func Mode() string {
+	if mode, ok := settings.Get("identity.node_id_mode").(string); ok && strings.EqualFold(mode, ModeHardware) {
+		return ModeHardware
+	}
+	return ModeKey
+}
+
+// FromPublicKey returns the NodeID that belongs to an identity public key.
+func FromPublicKey(pub []byte) string {
+	hash := sha256.Sum256(pub)
+	return hex.EncodeToString(hash[:])
+}
+
+var (
+	hardwareID   string
+	hardwareOnce sync.Once
+)
+
+// HardwareID generates a deterministic identifier for the current machine.
+// It collects OS-specific identifiers, joins them into a fingerprint, and returns a SHA-256 hash.
+// Cloned VMs and containers share this value, which is why it is only used in legacy mode.
+func HardwareID() string {
+	hardwareOnce.Do(func() {
+		hardwareID = fingerprintID()
+	})
+	return hardwareID
+}
+
+// fingerprintID hashes the OS-specific machine identifiers.
+func fingerprintID() string {
+	logger.Log("DEBUG", "NODEID", "Getting hardware NodeID...")
 	var parts []string
 
 	// Select fingerprinting strategy based on the operating system
@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"atsuko-nexus/src/logger"
+)
+
+// Anti-entropy sync (protocol v3) replaces the three full-list copies of SYNC with a digest
+// exchange. Peers are split into digestBuckets buckets by a hash of their NodeID; each bucket is
+// summarised by a hash over its NodeID+LastSeen pairs and the bucket hashes by a root.
+//
+//	initiator                          responder
+//	DIGEST {root, buckets}      ->
+//	                            <-     DELTA {differing buckets, responder's entries in them}
+//	DELTA {entries the responder
+//	       is missing or has older} ->
+//
+// When the roots match the responder answers with an empty DELTA and nothing else is sent.
+// Both sides merge with mergePeers, so the newest LastSeen still wins.
+const (
+	digestBuckets = 64
+
+	// versionAntiEntropy is the first protocol version that understands DIGEST/DELTA.
+	versionAntiEntropy uint8 = 3
+)
+
+// peerDigest summarises a peer list.
+type peerDigest struct {
+	Root    string   `json:"root"`
+	Buckets []string `json:"buckets"`
+}
+
+// peerDelta carries the entries of the buckets that differ.
+type peerDelta struct {
+	Buckets []int       `json:"buckets,omitempty"`
+	Entries []PeerEntry `json:"entries,omitempty"`
+}
+
+// digestBucket maps a NodeID to its bucket.
+func digestBucket(nodeID string) int {
+	sum := sha256.Sum256([]byte(nodeID))
+	return int(sum[0]) % digestBuckets
+}
+
+// computeDigest hashes each bucket's sorted NodeID+LastSeen pairs and then the bucket hashes.
+func computeDigest(peers []PeerEntry) peerDigest {
+	var grouped [digestBuckets][]PeerEntry
+	for _, p := range peers {
+		b := digestBucket(p.NodeID)
+		grouped[b] = append(grouped[b], p)
+	}
+
+	root := sha256.New()
+	d := peerDigest{Buckets: make([]string, digestBuckets)}
+	for i, bucket := range grouped {
+		sort.Slice(bucket, func(a, b int) bool { return bucket[a].NodeID < bucket[b].NodeID })
+		h := sha256.New()
+		for _, p := range bucket {
+			fmt.Fprintf(h, "%s|%s\n", p.NodeID, p.LastSeen)
+		}
+		sum := h.Sum(nil)
+		root.Write(sum)
+		d.Buckets[i] = hex.EncodeToString(sum)
+	}
+	d.Root = hex.EncodeToString(root.Sum(nil))
+	return d
+}
+
+// diffBuckets returns the indexes of buckets whose hashes differ.
+func diffBuckets(ours, theirs peerDigest) []int {
+	var diff []int
+	for i := range ours.Buckets {
+		if ours.Buckets[i] != theirs.Buckets[i] {
+			diff = append(diff, i)
+		}
+	}
+	return diff
+}
+
+// entriesInBuckets returns the peers that fall into the given buckets.
+func entriesInBuckets(peers []PeerEntry, buckets []int) []PeerEntry {
+	want := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		want[b] = true
+	}
+	var out []PeerEntry
+	for _, p := range peers {
+		if want[digestBucket(p.NodeID)] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// newerThan returns the entries of ours that theirs lacks or holds with an older LastSeen.
+func newerThan(ours, theirs []PeerEntry) []PeerEntry {
+	seen := make(map[string]PeerEntry, len(theirs))
+	for _, p := range theirs {
+		seen[p.NodeID] = p
+	}
+	var out []PeerEntry
+	for _, p := range ours {
+		t, ok := seen[p.NodeID]
+		if !ok || seenAfter(p, t) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// validBuckets reports whether every index is in range, so a hostile DELTA cannot make us
+// index out of bounds or ask for the same bucket twice.
+func validBuckets(buckets []int) bool {
+	seen := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		if b < 0 || b >= digestBuckets || seen[b] {
+			return false
+		}
+		seen[b] = true
+	}
+	return true
+}
+
+// syncAntiEntropy runs the initiator side of a digest sync on an established v3+ session.
+func syncAntiEntropy(pc *peerConn) error {
+	store := Peers()
+	if err := pc.send(MsgDigest, computeDigest(store.List())); err != nil {
+		return err
+	}
+
+	var theirs peerDelta
+	if err := pc.recvMax(MsgPeerDelta, &theirs, maxPeerListBytes()); err != nil {
+		return err
+	}
+	if len(theirs.Buckets) == 0 {
+		logger.Log("INFO", "tapsync", fmt.Sprintf("Peer list already in sync with %s", pc.remoteID))
+		return nil
+	}
+	if !validBuckets(theirs.Buckets) {
+		return fmt.Errorf("%w: DELTA names invalid buckets", errUnexpectedFrame)
+	}
+	if badOwnRecord(theirs.Entries, pc.remoteID) {
+		Reputations().RecordInvalid(pc.remoteID, "own record has a bad signature")
+	}
+	received := capPeerList(entriesInBuckets(theirs.Entries, theirs.Buckets), pc.remoteID)
+
+	// Work out what they are missing before merging, then fold their entries in.
+	ours := entriesInBuckets(store.List(), theirs.Buckets)
+	reply := newerThan(ours, received)
+	store.Merge(received)
+
+	if err := pc.send(MsgPeerDelta, peerDelta{Entries: reply}); err != nil {
+		return err
+	}
+	logger.Log("INFO", "tapsync", fmt.Sprintf("Reconciled %d buckets with %s: received %d entries, sent %d",
+		len(theirs.Buckets), pc.remoteID, len(received), len(reply)))
+	return nil
+}
+
+// serveAntiEntropy runs the responder side after a DIGEST frame has been read.
+func serveAntiEntropy(pc *peerConn, theirs peerDigest) error {
+	if len(theirs.Buckets) != digestBuckets {
+		return fmt.Errorf("%w: DIGEST has %d buckets, want %d", errUnexpectedFrame, len(theirs.Buckets), digestBuckets)
+	}
+
+	peers := refreshSelfEntry()
+	ours := computeDigest(peers)
+	if ours.Root == theirs.Root {
+		return pc.send(MsgPeerDelta, peerDelta{})
+	}
+
+	diff := diffBuckets(ours, theirs)
+	if err := pc.send(MsgPeerDelta, peerDelta{Buckets: diff, Entries: entriesInBuckets(peers, diff)}); err != nil {
+		return err
+	}
+
+	var back peerDelta
+	if err := pc.recvMax(MsgPeerDelta, &back, maxPeerListBytes()); err != nil {
+		return err
+	}
+	if badOwnRecord(back.Entries, pc.remoteID) {
+		Reputations().RecordInvalid(pc.remoteID, "own record has a bad signature")
+	}
+	received := capPeerList(entriesInBuckets(back.Entries, diff), pc.remoteID)
+	Peers().Merge(received)
+	logger.Log("INFO", "tapsync", fmt.Sprintf("Reconciled %d buckets with %s: sent %d entries, received %d",
+		len(diff), pc.remoteID, len(entriesInBuckets(peers, diff)), len(received)))
+	return nil
+}
@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewerThan(t *testing.T) {
+	now := time.Now()
+	ours := []PeerEntry{
+		peerSeen("same", now),
+		peerSeen("newer", now),
+		peerSeen("older", now.Add(-time.Hour)),
+		peerSeen("missing", now),
+	}
+	theirs := []PeerEntry{
+		peerSeen("same", now),
+		peerSeen("newer", now.Add(-time.Minute)),
+		peerSeen("older", now),
+		peerSeen("theirs-only", now),
+	}
+	got := byID(newerThan(ours, theirs))
+	if len(got) != 2 {
+		t.Errorf("newerThan returned %d entries, want 2: %v", len(got), got)
+	}
+	for _, id := range []string{"newer", "missing"} {
+		if _, ok := got[id]; !ok {
+			t.Errorf("newerThan left out %s", id)
+		}
+	}
+}
+
+func TestComputeDigest(t *testing.T) {
+	now := time.Now()
+	var peers []PeerEntry
+	for i := 0; i < 200; i++ {
+		peers = append(peers, peerSeen(fmt.Sprintf("node-%03d", i), now))
+	}
+	d := computeDigest(peers)
+	if len(d.Buckets) != digestBuckets {
+		t.Fatalf("digest has %d buckets, want %d", len(d.Buckets), digestBuckets)
+	}
+
+	reversed := make([]PeerEntry, len(peers))
+	for i, p := range peers {
+		reversed[len(peers)-1-i] = p
+	}
+	if computeDigest(reversed).Root != d.Root {
+		t.Error("digest depends on the order of the peer list")
+	}
+
+	changed := append([]PeerEntry(nil), peers...)
+	changed[42] = peerSeen(changed[42].NodeID, now.Add(time.Second))
+	other := computeDigest(changed)
+	if other.Root == d.Root {
+		t.Fatal("digest ignores a changed LastSeen")
+	}
+	diff := diffBuckets(d, other)
+	if len(diff) != 1 || diff[0] != digestBucket(changed[42].NodeID) {
+		t.Errorf("diff buckets %v, want only %d", diff, digestBucket(changed[42].NodeID))
+	}
+	if got := entriesInBuckets(changed, diff); len(newerThan(got, entriesInBuckets(peers, diff))) != 1 {
+		t.Errorf("delta for the differing bucket does not carry exactly the changed record")
+	}
+}
@@ -0,0 +1,169 @@
+package p2p
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/settings"
+)
+
+// connState is where a peer sits in the reconnect state machine:
+//
+//	healthy -> backing off (after a failed dial) -> suspect (attempts used up) -> dead (removed)
+//
+// Any successful session, dialed or accepted, returns the peer to healthy. A failure only moves a
+// peer along if some session succeeded since its previous failure; otherwise we cannot tell a
+// dead peer from our own lost uplink, and the peer table would be emptied on every outage. A peer
+// that keeps failing while we are isolated is only given up after isolatedDeadAfter.
+type connState int
+
+const (
+	StateHealthy connState = iota
+	StateBackingOff
+	StateSuspect
+	StateDead
+)
+
+// String returns a readable name for logging.
+func (s connState) String() string {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateBackingOff:
+		return "backing off"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+const (
+	// maxBackoff caps the delay between attempts however many have failed.
+	maxBackoff = time.Hour
+	// isolatedDeadAfter is how long a peer may keep failing, uncounted, while no session succeeds.
+	isolatedDeadAfter = 24 * time.Hour
+)
+
+// peerHealth tracks consecutive dial failures for one peer.
+type peerHealth struct {
+	state        connState
+	failures     int // failures counted towards reconnect_attempts
+	tries        int // every failed dial; drives the backoff delay
+	firstFailure time.Time
+	lastFailure  time.Time
+	nextAttempt  time.Time
+}
+
+// HealthTracker holds the reconnect state of every peer we have tried to dial.
+type HealthTracker struct {
+	mu    sync.Mutex
+	peers map[string]*peerHealth
+	// lastSuccess is when any session last succeeded; it tells a dead peer from a lost uplink.
+	lastSuccess time.Time
+}
+
+var (
+	health     *HealthTracker
+	healthOnce sync.Once
+)
+
+// Health returns the process-wide reconnect state tracker.
+func Health() *HealthTracker {
+	healthOnce.Do(func() {
+		health = &HealthTracker{peers: make(map[string]*peerHealth)}
+	})
+	return health
+}
+
+// reconnectAttempts returns network.reconnect_attempts, how many failed reconnects a peer gets
+// before it is considered suspect.
+func reconnectAttempts() int {
+	if n, ok := settings.Get("network.reconnect_attempts").(int); ok && n > 0 {
+		return n
+	}
+	return 5
+}
+
+// reconnectInterval returns network.reconnect_interval, the base backoff delay.
+func reconnectInterval() time.Duration {
+	if n, ok := settings.Get("network.reconnect_interval").(int); ok && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 15 * time.Second
+}
+
+// State returns nodeID's current state; peers we have never failed to reach are healthy.
+func (h *HealthTracker) State(nodeID string) connState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok := h.peers[nodeID]; ok {
+		return p.state
+	}
+	return StateHealthy
+}
+
+// Ready reports whether nodeID may be dialed now, i.e. its backoff delay has elapsed.
+func (h *HealthTracker) Ready(nodeID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.peers[nodeID]
+	return !ok || !time.Now().Before(p.nextAttempt)
+}
+
+// Succeeded resets nodeID to healthy.
+func (h *HealthTracker) Succeeded(nodeID string) {
+	h.mu.Lock()
+	delete(h.peers, nodeID)
+	h.lastSuccess = time.Now()
+	h.mu.Unlock()
+}
+
+// Failed records a failed dial and returns the new state along with the time of the next
+// attempt. The delay doubles per failure from reconnect_interval, with ±20% jitter so peers
+// that dropped together do not retry in lockstep. Once reconnect_attempts are used up the peer
+// turns suspect and gets one final attempt; failing that, it is dead. Failures while no session
+// has succeeded since the previous one are not counted.
+func (h *HealthTracker) Failed(nodeID string) (connState, time.Time) {
+	attempts := reconnectAttempts()
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.peers[nodeID]
+	if !ok {
+		p = &peerHealth{firstFailure: now}
+		h.peers[nodeID] = p
+	}
+	if !ok || h.lastSuccess.After(p.lastFailure) {
+		p.failures++
+	}
+	p.tries++
+	p.lastFailure = now
+
+	switch {
+	case p.failures > attempts || now.Sub(p.firstFailure) > isolatedDeadAfter:
+		p.state = StateDead
+		delete(h.peers, nodeID)
+		return StateDead, time.Time{}
+	case p.failures == attempts:
+		p.state = StateSuspect
+	default:
+		p.state = StateBackingOff
+	}
+
+	delay := reconnectInterval()
+	for i := 1; i < p.tries && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	delay = time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64()))
+	p.nextAttempt = now.Add(delay)
+	return p.state, p.nextAttempt
+}
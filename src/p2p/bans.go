@@ -0,0 +1,171 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// Ban is an entry in the ban list. Key is "node:<NodeID>" or "ip:<address>".
+type Ban struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	Since  string `json:"since"`
+	Until  string `json:"until"` // RFC3339; empty means permanent
+}
+
+// BanList holds active bans in memory and mirrors them to the bans bucket.
+type BanList struct {
+	mu   sync.Mutex
+	bans map[string]Ban
+}
+
+var errPeerBanned = errors.New("peer is banned")
+
+var (
+	banList     *BanList
+	banListOnce sync.Once
+)
+
+// Bans returns the process-wide ban list, loading it from the database on first use.
+func Bans() *BanList {
+	banListOnce.Do(func() {
+		banList = &BanList{bans: make(map[string]Ban)}
+		if _, err := db.Open(); err != nil {
+			logger.Log("ERROR", "peers", "Ban list running in memory only: "+err.Error())
+			return
+		}
+		err := db.ForEach(db.BucketBans, func(key string, value []byte) error {
+			var b Ban
+			if err := json.Unmarshal(value, &b); err == nil {
+				banList.bans[key] = b
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Log("ERROR", "peers", "Failed to load ban list: "+err.Error())
+		}
+	})
+	return banList
+}
+
+// banDuration returns reputation.ban_duration, how long automatic bans last.
+func banDuration() time.Duration {
+	if n, ok := settings.Get("reputation.ban_duration").(int); ok && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return time.Hour
+}
+
+// BanNode bans a NodeID for d; d <= 0 bans permanently.
+func (bl *BanList) BanNode(nodeID, reason string, d time.Duration) {
+	bl.add("node:"+nodeID, reason, d)
+}
+
+// BanIP bans a remote address for d; d <= 0 bans permanently.
+func (bl *BanList) BanIP(ip, reason string, d time.Duration) {
+	bl.add("ip:"+ip, reason, d)
+}
+
+// IsNodeBanned reports whether nodeID is currently banned.
+func (bl *BanList) IsNodeBanned(nodeID string) bool {
+	return nodeID != "" && bl.active("node:"+nodeID)
+}
+
+// IsIPBanned reports whether ip is currently banned.
+func (bl *BanList) IsIPBanned(ip string) bool {
+	return ip != "" && bl.active("ip:"+ip)
+}
+
+// Unban lifts a ban by key ("node:<id>" or "ip:<addr>").
+func (bl *BanList) Unban(key string) {
+	bl.mu.Lock()
+	_, ok := bl.bans[key]
+	if ok {
+		bl.removeLocked(key)
+	}
+	bl.mu.Unlock()
+	if ok {
+		logger.Log("INFO", "peers", "Lifted ban on "+key)
+	}
+}
+
+// removeLocked drops key from memory and the database. Call with bl.mu held, so a ban added
+// concurrently is never deleted from the database after it was saved.
+func (bl *BanList) removeLocked(key string) {
+	delete(bl.bans, key)
+	if err := db.Delete(db.BucketBans, key); err != nil {
+		logger.Log("ERROR", "peers", "Failed to delete ban: "+err.Error())
+	}
+}
+
+// List returns the active bans sorted by key.
+func (bl *BanList) List() []Ban {
+	bl.mu.Lock()
+	all := make([]Ban, 0, len(bl.bans))
+	for _, b := range bl.bans {
+		all = append(all, b)
+	}
+	bl.mu.Unlock()
+
+	var out []Ban
+	for _, b := range all {
+		if bl.active(b.Key) {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (bl *BanList) add(key, reason string, d time.Duration) {
+	now := time.Now().UTC()
+	b := Ban{Key: key, Reason: reason, Since: now.Format(time.RFC3339)}
+	if d > 0 {
+		b.Until = now.Add(d).Format(time.RFC3339)
+	}
+
+	bl.mu.Lock()
+	bl.bans[key] = b
+	if err := db.PutJSON(db.BucketBans, key, b); err != nil {
+		logger.Log("ERROR", "peers", "Failed to save ban: "+err.Error())
+	}
+	bl.mu.Unlock()
+
+	until := "permanently"
+	if b.Until != "" {
+		until = "until " + b.Until
+	}
+	logger.Log("WARN", "peers", fmt.Sprintf("Banned %s %s (%s)", strings.TrimPrefix(strings.TrimPrefix(key, "node:"), "ip:"), until, reason))
+}
+
+// active reports whether key is banned, lifting the ban if it has expired. The expiry check and
+// the removal happen under one lock, so a ban renewed in the meantime is never lifted.
+func (bl *BanList) active(key string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	b, ok := bl.bans[key]
+	if !ok {
+		return false
+	}
+	if b.Until == "" {
+		return true
+	}
+	until, err := parseTime(b.Until)
+	if err != nil {
+		logger.Log("WARN", "peers", fmt.Sprintf("Lifting ban on %s with unreadable expiry: %v", key, err))
+	} else if time.Now().Before(until) {
+		return true
+	}
+	bl.removeLocked(key)
+	logger.Log("INFO", "peers", "Lifted ban on "+key)
+	return false
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -9,19 +10,21 @@ import (
 	"strings"
 	"time"
 
-
+	"atsuko-nexus/src/hlc"
 	"atsuko-nexus/src/logger"
 	"atsuko-nexus/src/nodeid"
 	"atsuko-nexus/src/settings"
 	"atsuko-nexus/src/types"
 )
 
-// Bootstrap initializes peer list, adds self, and optionally connects to a bootstrap node
-func Bootstrap() {
-	exePath, _ := os.Executable()
-	exeDir := filepath.Dir(exePath)
-	peerPath := filepath.Join(exeDir, fmt.Sprint(settings.Get("storage.peer_cache_file")))
+// SeedPeersEnv names the environment variable holding a comma-separated list of IP:PORT seeds.
+const SeedPeersEnv = "ATSUKO_PEERS"
 
+// Bootstrap initializes peer list, adds self, and connects to a bootstrap node if we know nobody else.
+// Seeds come from cliPeers (the --peer flag), the ATSUKO_PEERS environment variable,
+// network.bootstrap_peers and network.seed_file. Without any seed the node prompts only when interactive
+// is set and stdin is a terminal; otherwise it stays in listen-only mode and keeps retrying in the background.
+func Bootstrap(ctx context.Context, cliPeers []string, interactive bool) {
 	port := settings.Get("network.listen_port").(int)
 	id := nodeid.GetNodeID()
 
@@ -38,16 +41,21 @@ func Bootstrap() {
 	localType := types.NodeType()
 	self := PeerEntry{
 		NodeID:   id,
-		Type: localType,
 		IPv4:     ipv4,
 		IPv6:     ipv6,
 		Port:     port,
-		LastSeen: time.Now().UTC().Format(time.RFC3339),
+		LastSeen: hlc.Default().Now().RFC3339(),
+		LegacyID: legacyID(),
 	}
+	stampRole(&self, localType)
+	signSelfEntry(&self)
 
-	peers := loadPeers(peerPath)
-	peers = upsertPeer(peers, self)
-	savePeers(peerPath, peers)
+	store := Peers()
+	if self.LegacyID != "" && self.LegacyID != id {
+		// Our own record under the old hardware ID is superseded by the key-derived one.
+		store.Remove(self.LegacyID)
+	}
+	store.Upsert(self)
 
 	tryUPnPForward(port)
 
@@ -58,36 +66,167 @@ func Bootstrap() {
 		logger.Log("WARN", "nexus", fmt.Sprintf("No active listener detected on port %d", port))
 	}
 
-	if len(peers) > 1 {
-		logger.Log("INFO", "nexus", fmt.Sprintf("Loaded %d peers.", (len(peers)-1)))
+	if n := store.Len(); n > 1 {
+		logger.Log("INFO", "nexus", fmt.Sprintf("Loaded %d peers.", (n-1)))
+		return
+	}
+
+	seeds := collectSeeds(cliPeers)
+	if len(seeds) > 0 {
+		if joinFromSeeds(seeds) {
+			return
+		}
+		logger.Log("WARN", "nexus", "No seed peer answered; continuing in listen-only mode.")
+		go retryBootstrap(ctx, seeds)
+		return
+	}
+
+	if !interactive || !stdinIsTerminal() {
+		logger.Log("WARN", "nexus", "No known peers and no seeds configured; continuing in listen-only mode.")
+		go retryBootstrap(ctx, nil)
 		return
 	}
 
 	fmt.Println("❗ No known peers found besides self.")
 	fmt.Println("Enter a known peer in IP:PORT format or type 'search' to attempt discovery.")
-	fmt.Println("⚠️ WARNING: Searching may take **months or longer** due to current network size.")
 	fmt.Print("➡️ Your input: ")
 
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		input, _ := reader.ReadString('\n')
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			logger.Log("WARN", "nexus", "Stdin closed before a peer was entered; continuing in listen-only mode.")
+			go retryBootstrap(ctx, nil)
+			return
+		}
 		input = strings.TrimSpace(input)
 
 		if input == "search" {
-			logger.Log("INFO", "nexus", "Search mode initiated (not yet implemented).")
+			logger.Log("INFO", "nexus", "Search mode initiated.")
+			DiscoverPeers()
 			break
 		}
 
 		if isValidPeer(input) {
-			logger.Log("INFO", "nexus", "Connecting to peer: "+input)
-			remotePeers := fetchPeerListTCP(input)
-			for _, rp := range remotePeers {
-				peers = upsertPeer(peers, rp)
+			if !joinFromSeeds([]string{input}) {
+				go retryBootstrap(ctx, []string{input})
 			}
-			savePeers(peerPath, peers)
 			break
 		}
 
 		fmt.Print("❌ Invalid format. Enter IP:PORT or type 'search': ")
 	}
 }
+
+// joinFromSeeds asks each seed for its peer list until one answers, then walks the DHT from there.
+// It reports whether any seed answered.
+func joinFromSeeds(seeds []string) bool {
+	for _, seed := range seeds {
+		logger.Log("INFO", "nexus", "Connecting to peer: "+seed)
+		remotePeers := fetchPeerListTCP(seed)
+		if len(remotePeers) == 0 {
+			continue
+		}
+
+		Peers().Merge(remotePeers)
+
+		// One known address is enough: walk the DHT from it to find the rest.
+		rt := dhtTable()
+		for _, rp := range remotePeers {
+			rt.Add(rp)
+		}
+		DiscoverPeers()
+		return true
+	}
+	return false
+}
+
+// retryBootstrap keeps trying the seeds every network.reconnect_interval seconds until the peer
+// cache holds someone besides us, which may also happen through LAN discovery or an inbound sync.
+// It gives up when ctx is cancelled.
+func retryBootstrap(ctx context.Context, seeds []string) {
+	interval := 15 * time.Second
+	if sec, ok := settings.Get("network.reconnect_interval").(int); ok && sec > 0 {
+		interval = time.Duration(sec) * time.Second
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if Peers().Len() > 1 {
+			logger.Log("INFO", "nexus", "Peers found; leaving listen-only mode.")
+			return
+		}
+		if len(seeds) > 0 && joinFromSeeds(seeds) {
+			return
+		}
+	}
+}
+
+// collectSeeds gathers bootstrap addresses from every configured source, dropping duplicates
+// and anything that is not IP:PORT.
+func collectSeeds(cliPeers []string) []string {
+	var raw []string
+	raw = append(raw, cliPeers...)
+	raw = append(raw, strings.Split(os.Getenv(SeedPeersEnv), ",")...)
+	if list, ok := settings.Get("network.bootstrap_peers").([]interface{}); ok {
+		for _, v := range list {
+			raw = append(raw, fmt.Sprint(v))
+		}
+	}
+	raw = append(raw, readSeedFile()...)
+
+	seen := make(map[string]bool)
+	var seeds []string
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		if !isValidPeer(s) {
+			logger.Log("WARN", "nexus", "Ignoring invalid seed peer: "+s)
+			continue
+		}
+		seeds = append(seeds, s)
+	}
+	return seeds
+}
+
+// readSeedFile reads network.seed_file: one IP:PORT per line, '#' starts a comment.
+func readSeedFile() []string {
+	rel, _ := settings.Get("network.seed_file").(string)
+	if rel == "" {
+		return nil
+	}
+	path := rel
+	if !filepath.IsAbs(path) {
+		exePath, _ := os.Executable()
+		path = filepath.Join(filepath.Dir(exePath), rel)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Log("WARN", "nexus", fmt.Sprintf("Failed to read seed file %s: %v", path, err))
+		return nil
+	}
+
+	var out []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
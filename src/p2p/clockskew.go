@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/logger"
+)
+
+// peerSkew is what we know about one peer's clock.
+type peerSkew struct {
+	offset   time.Duration // their clock minus ours, as measured at the last handshake
+	measured time.Time
+	rejected int // peer records refused for being stamped beyond the skew bound
+}
+
+// SkewTracker keeps the clock offset measured from each peer during the handshake and counts the
+// records refused because their timestamps were too far in the future.
+type SkewTracker struct {
+	mu    sync.Mutex
+	peers map[string]*peerSkew
+}
+
+// ClockSkewStats is a snapshot of the tracker for display.
+type ClockSkewStats struct {
+	Measured int           // peers with a measured offset
+	Worst    time.Duration // largest offset by magnitude, signed
+	WorstID  string
+	Rejected int // records refused across all peers
+}
+
+var (
+	skews     *SkewTracker
+	skewsOnce sync.Once
+)
+
+// Skews returns the process-wide clock skew tracker.
+func Skews() *SkewTracker {
+	skewsOnce.Do(func() {
+		skews = &SkewTracker{peers: make(map[string]*peerSkew)}
+	})
+	return skews
+}
+
+// GetClockSkewStats returns the current clock skew summary.
+func GetClockSkewStats() ClockSkewStats {
+	return Skews().Stats()
+}
+
+// entry returns the record for nodeID, creating it. Callers must hold mu.
+func (st *SkewTracker) entry(nodeID string) *peerSkew {
+	s, ok := st.peers[nodeID]
+	if !ok {
+		s = &peerSkew{}
+		st.peers[nodeID] = s
+	}
+	return s
+}
+
+// Record stores the offset measured from nodeID's clock and warns once it reaches half the skew
+// bound, before that peer's records start being refused.
+func (st *SkewTracker) Record(nodeID string, offset time.Duration) {
+	st.mu.Lock()
+	s := st.entry(nodeID)
+	s.offset, s.measured = offset, time.Now()
+	st.mu.Unlock()
+
+	offset = offset.Round(time.Millisecond)
+	if limit := hlc.MaxSkew(); abs(offset) > limit/2 {
+		logger.Log("WARN", "peers", fmt.Sprintf("Clock of %s is off by %s (limit %s)", nodeID, offset, limit))
+		return
+	}
+	logger.Log("DEBUG", "peers", fmt.Sprintf("Clock of %s is off by %s", nodeID, offset))
+}
+
+// Rejected counts a record about nodeID refused for a timestamp beyond the skew bound.
+func (st *SkewTracker) Rejected(nodeID string) {
+	st.mu.Lock()
+	st.entry(nodeID).rejected++
+	st.mu.Unlock()
+}
+
+// Get returns the last offset measured from nodeID and whether there is one.
+func (st *SkewTracker) Get(nodeID string) (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.peers[nodeID]
+	if !ok || s.measured.IsZero() {
+		return 0, false
+	}
+	return s.offset, true
+}
+
+// Forget drops everything known about nodeID.
+func (st *SkewTracker) Forget(nodeID string) {
+	st.mu.Lock()
+	delete(st.peers, nodeID)
+	st.mu.Unlock()
+}
+
+// Stats summarises the tracker.
+func (st *SkewTracker) Stats() ClockSkewStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var out ClockSkewStats
+	for id, s := range st.peers {
+		out.Rejected += s.rejected
+		if s.measured.IsZero() {
+			continue
+		}
+		out.Measured++
+		if abs(s.offset) > abs(out.Worst) {
+			out.Worst, out.WorstID = s.offset, id
+		}
+	}
+	return out
+}
+
+// handshakeTime is the wall-clock reading exchanged in HELLO and HELLO-ACK.
+func handshakeTime() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// recordHandshakeSkew measures nodeID's clock from the time it sent, assuming it was read at
+// midpoint. Older peers send no time and are skipped.
+func recordHandshakeSkew(nodeID, sent string, midpoint time.Time) {
+	if sent == "" {
+		return
+	}
+	theirs, err := parseTime(sent)
+	if err != nil {
+		logger.Log("DEBUG", "peers", fmt.Sprintf("Ignoring handshake time from %s: %v", nodeID, err))
+		return
+	}
+	Skews().Record(nodeID, theirs.Sub(midpoint))
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
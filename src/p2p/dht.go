@@ -0,0 +1,378 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+)
+
+// The DHT is a Kademlia-style routing table keyed on NodeIDs. Distance between two nodes is
+// the XOR of their 256-bit IDs, each k-bucket holds up to dhtK peers sharing a common prefix
+// length with us, and FIND_NODE lookups walk towards a target in a handful of round trips.
+const (
+	dhtIDBits       = 256
+	dhtK            = 20
+	dhtAlpha        = 3
+	dhtRefreshAfter = time.Hour
+	dhtStaleAfter   = time.Hour
+
+	// versionFindNode is the first protocol version that understands FIND_NODE.
+	versionFindNode uint8 = 2
+)
+
+type dhtID [32]byte
+
+// findNodePayload asks a node for the peers it knows closest to Target.
+type findNodePayload struct {
+	Target string `json:"target"`
+}
+
+// kBucket holds peers ordered from least to most recently seen.
+type kBucket struct {
+	entries     []PeerEntry
+	lastRefresh time.Time
+}
+
+// RoutingTable is the node's view of the DHT.
+type RoutingTable struct {
+	mu      sync.Mutex
+	self    dhtID
+	buckets [dhtIDBits]kBucket
+}
+
+var (
+	routingTable *RoutingTable
+	routingOnce  sync.Once
+)
+
+// dhtTable returns the process-wide routing table, seeded from the peer cache on first use.
+func dhtTable() *RoutingTable {
+	routingOnce.Do(func() {
+		routingTable = newRoutingTable(nodeid.GetNodeID())
+		for _, p := range Peers().List() {
+			routingTable.Add(p)
+		}
+		logger.Log("DEBUG", "dht", fmt.Sprintf("Routing table seeded with %d peers", routingTable.Size()))
+	})
+	return routingTable
+}
+
+func newRoutingTable(selfID string) *RoutingTable {
+	return &RoutingTable{self: toDHTID(selfID)}
+}
+
+// toDHTID maps a NodeID onto the 256-bit keyspace. NodeIDs are hex SHA-256 digests; anything
+// else is hashed so it still lands somewhere stable.
+func toDHTID(nodeID string) dhtID {
+	var id dhtID
+	if raw, err := hex.DecodeString(nodeID); err == nil && len(raw) == len(id) {
+		copy(id[:], raw)
+		return id
+	}
+	return sha256.Sum256([]byte(nodeID))
+}
+
+// xorDistance returns a XOR b.
+func xorDistance(a, b dhtID) dhtID {
+	var d dhtID
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// lessDistance reports whether distance a is smaller than distance b.
+func lessDistance(a, b dhtID) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// bucketIndex returns the index of the highest differing bit between self and id, or -1 for self.
+func (rt *RoutingTable) bucketIndex(id dhtID) int {
+	d := xorDistance(rt.self, id)
+	for i, b := range d {
+		if b != 0 {
+			return dhtIDBits - 1 - (i*8 + bits.LeadingZeros8(b))
+		}
+	}
+	return -1
+}
+
+// Add inserts or refreshes a peer. A full bucket only makes room by dropping its least
+// recently seen entry once that entry has gone stale.
+func (rt *RoutingTable) Add(p PeerEntry) {
+	if p.NodeID == "" || net.ParseIP(p.IPv4) == nil {
+		return
+	}
+	idx := rt.bucketIndex(toDHTID(p.NodeID))
+	if idx < 0 {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	b := &rt.buckets[idx]
+	for i, e := range b.entries {
+		if e.NodeID == p.NodeID {
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			b.entries = append(b.entries, p)
+			return
+		}
+	}
+	if len(b.entries) < dhtK {
+		b.entries = append(b.entries, p)
+		return
+	}
+	if seen, ok := lastSeen(b.entries[0]); !ok || time.Since(seen) > dhtStaleAfter {
+		b.entries = append(b.entries[1:], p)
+	}
+}
+
+// Remove drops a peer from the table.
+func (rt *RoutingTable) Remove(nodeID string) {
+	idx := rt.bucketIndex(toDHTID(nodeID))
+	if idx < 0 {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	b := &rt.buckets[idx]
+	for i, e := range b.entries {
+		if e.NodeID == nodeID {
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Closest returns up to n known peers ordered by XOR distance to target.
+func (rt *RoutingTable) Closest(target dhtID, n int) []PeerEntry {
+	rt.mu.Lock()
+	var all []PeerEntry
+	for i := range rt.buckets {
+		all = append(all, rt.buckets[i].entries...)
+	}
+	rt.mu.Unlock()
+
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// Entries returns every peer in the table.
+func (rt *RoutingTable) Entries() []PeerEntry {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var all []PeerEntry
+	for i := range rt.buckets {
+		all = append(all, rt.buckets[i].entries...)
+	}
+	return all
+}
+
+// Size returns the number of peers in the table.
+func (rt *RoutingTable) Size() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	n := 0
+	for i := range rt.buckets {
+		n += len(rt.buckets[i].entries)
+	}
+	return n
+}
+
+// staleBuckets returns the indexes of non-empty buckets that have not been looked up recently.
+func (rt *RoutingTable) staleBuckets() []int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var out []int
+	for i := range rt.buckets {
+		b := &rt.buckets[i]
+		if len(b.entries) > 0 && time.Since(b.lastRefresh) > dhtRefreshAfter {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// markRefreshed records that the bucket covering target was just looked up.
+func (rt *RoutingTable) markRefreshed(target dhtID) {
+	idx := rt.bucketIndex(target)
+	if idx < 0 {
+		return
+	}
+	rt.mu.Lock()
+	rt.buckets[idx].lastRefresh = time.Now()
+	rt.mu.Unlock()
+}
+
+// randomIDInBucket returns a random ID that falls into bucket idx.
+func (rt *RoutingTable) randomIDInBucket(idx int) dhtID {
+	var id dhtID
+	_, _ = rand.Read(id[:])
+	// Keep the bits above idx equal to ours, flip bit idx, randomise the rest.
+	byteIdx := (dhtIDBits - 1 - idx) / 8
+	bitIdx := uint(7 - (dhtIDBits-1-idx)%8)
+	for i := 0; i < byteIdx; i++ {
+		id[i] = rt.self[i]
+	}
+	mask := byte(0xFF) << (bitIdx + 1)
+	id[byteIdx] = (rt.self[byteIdx] & mask) | (^rt.self[byteIdx] & (1 << bitIdx)) | (id[byteIdx] &^ (mask | 1<<bitIdx))
+	return id
+}
+
+// sortByDistance orders peers by XOR distance to target, closest first.
+func sortByDistance(peers []PeerEntry, target dhtID) {
+	sort.Slice(peers, func(i, j int) bool {
+		return lessDistance(xorDistance(toDHTID(peers[i].NodeID), target), xorDistance(toDHTID(peers[j].NodeID), target))
+	})
+}
+
+// findNodeRPC asks peer for the nodes it knows closest to target.
+func findNodeRPC(peer PeerEntry, target dhtID) ([]PeerEntry, error) {
+	pc, err := dialNexus(net.JoinHostPort(peer.IPv4, fmt.Sprint(peer.Port)), peer.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	if pc.version < versionFindNode {
+		return nil, fmt.Errorf("peer %s speaks protocol v%d, FIND_NODE needs v%d", peer.NodeID, pc.version, versionFindNode)
+	}
+	if err := pc.send(MsgFindNode, findNodePayload{Target: hex.EncodeToString(target[:])}); err != nil {
+		return nil, err
+	}
+	var nodes []PeerEntry
+	if err := pc.recvMax(MsgNodes, &nodes, dhtK*maxPeerRecordBytes); err != nil {
+		return nil, err
+	}
+	if len(nodes) > dhtK {
+		nodes = nodes[:dhtK]
+	}
+	return nodes, nil
+}
+
+// lookup performs an iterative FIND_NODE search for target and returns the closest peers found.
+// Every peer learned along the way is added to the routing table.
+func (rt *RoutingTable) lookup(target dhtID) []PeerEntry {
+	selfID := nodeid.GetNodeID()
+	shortlist := rt.Closest(target, dhtK)
+	seen := make(map[string]bool, len(shortlist))
+	for _, p := range shortlist {
+		seen[p.NodeID] = true
+	}
+	queried := make(map[string]bool)
+
+	for {
+		var batch []PeerEntry
+		for _, p := range shortlist {
+			if !queried[p.NodeID] {
+				batch = append(batch, p)
+				if len(batch) == dhtAlpha {
+					break
+				}
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var (
+			mu    sync.Mutex
+			wg    sync.WaitGroup
+			found []PeerEntry
+		)
+		for _, p := range batch {
+			queried[p.NodeID] = true
+			wg.Add(1)
+			go func(p PeerEntry) {
+				defer wg.Done()
+				nodes, err := findNodeRPC(p, target)
+				if err != nil {
+					logger.Log("DEBUG", "dht", fmt.Sprintf("FIND_NODE to %s failed: %v", p.NodeID, err))
+					rt.Remove(p.NodeID)
+					return
+				}
+				rt.Add(p)
+				mu.Lock()
+				found = append(found, nodes...)
+				mu.Unlock()
+			}(p)
+		}
+		wg.Wait()
+
+		closestBefore := shortlist[0]
+		for _, n := range found {
+			if n.NodeID == selfID || seen[n.NodeID] {
+				continue
+			}
+			if err := admitPeer(nil, n); err != nil {
+				logger.Log("WARN", "dht", fmt.Sprintf("Ignoring node %s from lookup: %v", n.NodeID, err))
+				continue
+			}
+			seen[n.NodeID] = true
+			shortlist = append(shortlist, n)
+			rt.Add(n)
+		}
+		sortByDistance(shortlist, target)
+		if len(shortlist) > dhtK {
+			shortlist = shortlist[:dhtK]
+		}
+
+		// Stop once a round brings nothing closer and the k closest have all answered.
+		if shortlist[0].NodeID == closestBefore.NodeID {
+			done := true
+			for _, p := range shortlist {
+				if !queried[p.NodeID] {
+					done = false
+					break
+				}
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	rt.markRefreshed(target)
+	return shortlist
+}
+
+// DiscoverPeers looks up our own NodeID, which fills the buckets nearest to us, refreshes
+// any bucket that has not been searched within the last hour, and writes what was learned
+// back to the peer cache.
+func DiscoverPeers() {
+	rt := dhtTable()
+	if rt.Size() == 0 {
+		logger.Log("WARN", "dht", "Routing table is empty; nothing to search from.")
+		return
+	}
+
+	before := rt.Size()
+	rt.lookup(rt.self)
+	for _, idx := range rt.staleBuckets() {
+		rt.lookup(rt.randomIDInBucket(idx))
+	}
+
+	saveRoutingTable(rt)
+	logger.Log("INFO", "dht", fmt.Sprintf("Discovery finished: %d peers in routing table (%+d)", rt.Size(), rt.Size()-before))
+}
+
+// saveRoutingTable merges the routing table into the peer store.
+func saveRoutingTable(rt *RoutingTable) {
+	Peers().Merge(rt.Entries())
+}
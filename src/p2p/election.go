@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"atsuko-nexus/src/election"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
+)
+
+// peerMembership feeds leader election from the peer store: candidates are peers whose Type is
+// listed in election.eligible_types and whose LastSeen is within election.liveness_timeout.
+// "admin" and "bot" peers only keep that Type with a valid role proof; other types are taken on trust.
+type peerMembership struct {
+	self         string
+	selfEligible bool
+}
+
+func (m peerMembership) Self() string { return m.self }
+
+// Candidates returns every live, eligible NodeID. Peers we failed to reach until they turned
+// suspect or dead are left out even if gossip still carries a recent LastSeen for them.
+func (m peerMembership) Candidates() []string {
+	eligible := eligibleTypes()
+	cutoff := time.Now().Add(-livenessTimeout())
+	var out []string
+	if m.selfEligible {
+		out = append(out, m.self)
+	}
+	for _, p := range Peers().List() {
+		if p.NodeID == m.self || !eligible[p.Type] || Bans().IsNodeBanned(p.NodeID) {
+			continue
+		}
+		if seen, ok := lastSeen(p); !ok || !seen.After(cutoff) || Health().State(p.NodeID) >= StateSuspect {
+			continue
+		}
+		out = append(out, p.NodeID)
+	}
+	return out
+}
+
+// eligibleTypes returns election.eligible_types as a set.
+func eligibleTypes() map[string]bool {
+	list, _ := settings.Get("election.eligible_types").([]interface{})
+	out := make(map[string]bool, len(list))
+	for _, item := range list {
+		out[fmt.Sprint(item)] = true
+	}
+	return out
+}
+
+// livenessTimeout returns election.liveness_timeout, how stale a candidate's LastSeen may be.
+func livenessTimeout() time.Duration {
+	if sec, ok := settings.Get("election.liveness_timeout").(int); ok && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// NewElection creates the leader elector for this process, working from the peer store, and
+// installs it as election.Default. Start it with the lifecycle manager.
+func NewElection() *election.Elector {
+	self := nodeid.GetNodeID()
+	localType := types.NodeType()
+	m := peerMembership{self: self, selfEligible: eligibleTypes()[localType]}
+	e := election.New(m, election.ConfigFromSettings())
+	election.SetDefault(e)
+	logger.Log("DEBUG", "election", fmt.Sprintf("Leader election ready (this node is %s, eligible: %v)", localType, m.selfEligible))
+	return e
+}
@@ -0,0 +1,153 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+
+	"atsuko-nexus/src/gossip"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+)
+
+const (
+	// versionGossip is the first protocol version that carries GOSSIP frames.
+	versionGossip uint8 = 4
+
+	// maxGossipBytes caps a single pushed gossip message.
+	maxGossipBytes = 64 << 10
+
+	// maxGossipHandlers bounds the pushed messages being delivered and forwarded at once, across
+	// all sessions; pushes arriving while every slot is busy are dropped and left to pulls.
+	maxGossipHandlers = 16
+)
+
+// gossipSlots is the semaphore behind maxGossipHandlers.
+var gossipSlots = make(chan struct{}, maxGossipHandlers)
+
+// handleGossipPush delivers and forwards a pushed message off the session, so the pusher is not
+// held up while we dial other peers. It reports false if the message was dropped for lack of a slot.
+func handleGossipPush(node *gossip.Node, from string, msg gossip.Message) bool {
+	select {
+	case gossipSlots <- struct{}{}:
+	default:
+		logger.Log("DEBUG", "gossip", fmt.Sprintf("Dropping message %s from %s: too many pushes in flight", msg.ID, from))
+		return false
+	}
+	go func() {
+		defer func() { <-gossipSlots }()
+		node.HandlePush(from, msg)
+	}()
+	return true
+}
+
+// gossipPullPayload lists the message IDs the puller already has.
+type gossipPullPayload struct {
+	Have []string `json:"have"`
+}
+
+// nexusTransport carries gossip over short-lived Nexus sessions.
+type nexusTransport struct{}
+
+// Peers returns the NodeIDs worth gossiping with: known, dialable, not banned and not backing off.
+func (nexusTransport) Peers() []string {
+	selfID := nodeid.GetNodeID()
+	var out []string
+	for _, p := range Peers().List() {
+		if p.NodeID == selfID || net.ParseIP(p.IPv4) == nil {
+			continue
+		}
+		if Bans().IsNodeBanned(p.NodeID) || !Health().Ready(p.NodeID) {
+			continue
+		}
+		out = append(out, p.NodeID)
+	}
+	return out
+}
+
+// Push delivers msg to nodeID in a single GOSSIP frame.
+func (nexusTransport) Push(nodeID string, msg gossip.Message) error {
+	pc, err := dialGossipPeer(nodeID)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	return pc.send(MsgGossip, msg)
+}
+
+// Pull asks nodeID for the messages whose IDs are not in have.
+func (nexusTransport) Pull(nodeID string, have []string) ([]gossip.Message, error) {
+	pc, err := dialGossipPeer(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	if err := pc.send(MsgGossipPull, gossipPullPayload{Have: have}); err != nil {
+		return nil, err
+	}
+	var msgs []gossip.Message
+	if err := pc.recv(MsgGossipMessages, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// dialGossipPeer opens a session with a known peer and checks it speaks gossip.
+func dialGossipPeer(nodeID string) (*peerConn, error) {
+	return dialKnownPeer(nodeID, versionGossip, "gossip")
+}
+
+// dialKnownPeer opens a session with a peer from the store and checks it speaks at least version
+// need, which feature names in the error.
+func dialKnownPeer(nodeID string, need uint8, feature string) (*peerConn, error) {
+	peer, ok := Peers().Get(nodeID)
+	if !ok {
+		return nil, fmt.Errorf("unknown peer %s", nodeID)
+	}
+	pc, err := dialNexus(net.JoinHostPort(peer.IPv4, fmt.Sprint(peer.Port)), nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if pc.version < need {
+		pc.Close()
+		return nil, fmt.Errorf("peer %s speaks protocol v%d, %s needs v%d", nodeID, pc.version, feature, need)
+	}
+	return pc, nil
+}
+
+// addressTransport pushes to fixed Nexus addresses instead of known peers. It lets a short-lived
+// process (such as the admin CLI) hand a message to a running node, which gossips it onward.
+type addressTransport struct {
+	addrs []string
+}
+
+// AddressTransport returns a gossip transport whose peers are the given IP:PORT addresses.
+func AddressTransport(addrs []string) gossip.Transport {
+	return addressTransport{addrs: addrs}
+}
+
+func (t addressTransport) Peers() []string { return t.addrs }
+
+func (t addressTransport) Push(addr string, msg gossip.Message) error {
+	pc, err := dialNexus(addr, "")
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	if pc.version < versionGossip {
+		return fmt.Errorf("%s speaks protocol v%d, gossip needs v%d", addr, pc.version, versionGossip)
+	}
+	return pc.send(MsgGossip, msg)
+}
+
+func (t addressTransport) Pull(string, []string) ([]gossip.Message, error) {
+	return nil, nil
+}
+
+// NewGossip creates the gossip node for this process, running over Nexus sessions, and installs
+// it as gossip.Default so other packages can publish and subscribe. Start it with the lifecycle manager.
+func NewGossip() *gossip.Node {
+	n := gossip.New(nodeid.GetNodeID(), nexusTransport{}, gossip.ConfigFromSettings())
+	gossip.SetDefault(n)
+	logger.Log("DEBUG", "gossip", "Gossip layer ready")
+	return n
+}
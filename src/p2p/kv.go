@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"fmt"
+
+	"atsuko-nexus/src/kv"
+	"atsuko-nexus/src/logger"
+)
+
+// Replicated KV sync (protocol v6) runs on the TapSync session after the peer list has been
+// reconciled, with the same three-step digest exchange as anti-entropy for peers:
+//
+//	initiator                          responder
+//	KV-DIGEST {root, buckets}   ->
+//	                            <-     KV-DELTA {differing buckets, responder's entries in them}
+//	KV-DELTA {entries the responder
+//	          is missing or has older,
+//	          v8+: every key version
+//	          held in those buckets} ->
+//	                            <-     KV-DELTA {v8+: responder's entries the
+//	                                             initiator is missing or has older}
+//
+// Each delta is capped at kv.MaxDeltaEntries. From v8 the initiator lists what it holds, so the
+// last delta pages past the keys it already has and a node that only ever initiates, such as one
+// behind NAT, still catches up on a store larger than one delta over successive syncs.
+//
+// Entries are merged last-writer-wins by their hybrid logical clock stamps.
+const (
+	versionKV       uint8 = 6
+	versionKVPaging uint8 = 8
+)
+
+// kvDelta carries the entries of the buckets that differ.
+type kvDelta struct {
+	Buckets []int        `json:"buckets,omitempty"`
+	Entries []kv.Entry   `json:"entries,omitempty"`
+	Have    []kv.Version `json:"have,omitempty"`
+}
+
+// syncKV runs the initiator side of a KV digest sync on an established v6+ session.
+func syncKV(pc *peerConn) error {
+	store := kv.Default()
+	if err := pc.send(MsgKVDigest, store.Digest()); err != nil {
+		return err
+	}
+
+	var theirs kvDelta
+	if err := pc.recv(MsgKVDelta, &theirs); err != nil {
+		return err
+	}
+	if len(theirs.Buckets) == 0 {
+		logger.Log("DEBUG", "kv", fmt.Sprintf("Replicated store already in sync with %s", pc.remoteID))
+		return nil
+	}
+	if !kv.ValidBuckets(theirs.Buckets) {
+		return fmt.Errorf("%w: KV-DELTA names invalid buckets", errUnexpectedFrame)
+	}
+
+	// Work out what they are missing before merging, then fold their entries in.
+	reply := kvDelta{Entries: store.NewerThan(theirs.Buckets, kv.VersionsOf(theirs.Entries))}
+	changed := store.Merge(theirs.Entries)
+	paging := pc.version >= versionKVPaging
+	if paging {
+		reply.Have = store.VersionsIn(theirs.Buckets)
+	}
+
+	if err := pc.send(MsgKVDelta, reply); err != nil {
+		return err
+	}
+	if paging {
+		var page kvDelta
+		if err := pc.recv(MsgKVDelta, &page); err != nil {
+			return err
+		}
+		changed = append(changed, store.Merge(page.Entries)...)
+	}
+	logger.Log("INFO", "kv", fmt.Sprintf("Reconciled %d buckets with %s: %d keys updated, sent %d",
+		len(theirs.Buckets), pc.remoteID, len(changed), len(reply.Entries)))
+	return nil
+}
+
+// serveKV runs the responder side of a KV digest sync.
+func serveKV(pc *peerConn, theirs kv.Digest) error {
+	if !theirs.Valid() {
+		return fmt.Errorf("%w: KV-DIGEST has %d buckets, want %d", errUnexpectedFrame, len(theirs.Buckets), kv.DigestBuckets)
+	}
+
+	store := kv.Default()
+	ours := store.Digest()
+	if ours.Root == theirs.Root {
+		return pc.send(MsgKVDelta, kvDelta{})
+	}
+
+	diff := kv.DiffBuckets(ours, theirs)
+	if err := pc.send(MsgKVDelta, kvDelta{Buckets: diff, Entries: store.EntriesIn(diff)}); err != nil {
+		return err
+	}
+
+	var back kvDelta
+	if err := pc.recv(MsgKVDelta, &back); err != nil {
+		return err
+	}
+	changed := store.Merge(back.Entries)
+	sent := 0
+	if pc.version >= versionKVPaging {
+		page := store.NewerThan(diff, back.Have)
+		if err := pc.send(MsgKVDelta, kvDelta{Entries: page}); err != nil {
+			return err
+		}
+		sent = len(page)
+	}
+	logger.Log("INFO", "kv", fmt.Sprintf("Reconciled %d buckets with %s: %d keys updated, paged %d more",
+		len(diff), pc.remoteID, len(changed), sent))
+	return nil
+}
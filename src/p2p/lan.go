@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/hlc"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
+)
+
+// LAN discovery announces the node on a UDP multicast group so nodes on the same subnet or
+// Docker network find each other without any manual input or internet access.
+const (
+	lanBeaconGroup    = "239.255.77.13:51614"
+	lanBeaconInterval = 15 * time.Second
+	lanBeaconMagic    = "atsuko-nexus"
+	lanMaxBeaconSize  = 1024
+)
+
+// lanBeacon is the datagram each node multicasts.
+type lanBeacon struct {
+	Magic     string `json:"magic"`
+	NodeID    string `json:"node_id"`
+	Port      int    `json:"port"`
+	Protocol  uint8  `json:"protocol"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// LANDiscovery is the beacon sender and listener service.
+type LANDiscovery struct {
+	conn   *net.UDPConn
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLANDiscovery returns the LAN discovery service; it does nothing unless network.allow_lan_peers is on.
+func NewLANDiscovery() *LANDiscovery {
+	return &LANDiscovery{}
+}
+
+// Name identifies the service in lifecycle logs.
+func (d *LANDiscovery) Name() string { return "lan discovery" }
+
+// Start joins the beacon group and begins announcing this node.
+func (d *LANDiscovery) Start(ctx context.Context) error {
+	if settings.Get("network.allow_lan_peers") != true {
+		logger.Log("DEBUG", "lan", "LAN discovery disabled by network.allow_lan_peers")
+		return nil
+	}
+	group, err := net.ResolveUDPAddr("udp4", lanBeaconGroup)
+	if err != nil {
+		logger.Log("ERROR", "lan", "Invalid beacon group: "+err.Error())
+		return nil
+	}
+
+	ctx, d.cancel = context.WithCancel(ctx)
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		logger.Log("ERROR", "lan", "Failed to join beacon group: "+err.Error())
+	} else {
+		d.conn = conn
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			listenLANBeacons(ctx, conn)
+		}()
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		sendLANBeacons(ctx, group)
+	}()
+	logger.Log("INFO", "lan", "LAN discovery active on "+lanBeaconGroup)
+	return nil
+}
+
+// Stop leaves the beacon group and stops announcing.
+func (d *LANDiscovery) Stop(ctx context.Context) error {
+	if d.cancel == nil {
+		return nil
+	}
+	d.cancel()
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendLANBeacons announces this node on the multicast group at a fixed interval.
+func sendLANBeacons(ctx context.Context, group *net.UDPAddr) {
+	beacon := lanBeacon{
+		Magic:    lanBeaconMagic,
+		NodeID:   nodeid.GetNodeID(),
+		Port:     settings.Get("network.listen_port").(int),
+		Protocol: ProtocolVersion,
+	}
+	if id := types.LocalIdentity(); id != nil {
+		beacon.PublicKey = id.PublicHex()
+	}
+	data, _ := json.Marshal(beacon)
+
+	for {
+		conn, err := net.DialUDP("udp4", nil, group)
+		if err != nil {
+			logger.Log("DEBUG", "lan", "Beacon send failed: "+err.Error())
+		} else {
+			if _, err := conn.Write(data); err != nil {
+				logger.Log("DEBUG", "lan", "Beacon send failed: "+err.Error())
+			}
+			conn.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lanBeaconInterval):
+		}
+	}
+}
+
+// listenLANBeacons adds every new node heard on the multicast group to the peer cache.
+func listenLANBeacons(ctx context.Context, conn *net.UDPConn) {
+	conn.SetReadBuffer(64 * 1024)
+
+	buf := make([]byte, lanMaxBeaconSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Log("DEBUG", "lan", "Beacon read failed: "+err.Error())
+			continue
+		}
+		var b lanBeacon
+		if err := json.Unmarshal(buf[:n], &b); err != nil || b.Magic != lanBeaconMagic {
+			continue
+		}
+		handleLANBeacon(b, src.IP)
+	}
+}
+
+// lanProbing holds the NodeIDs whose beacons are being checked, so a chatty sender is dialled once.
+var lanProbing sync.Map
+
+// handleLANBeacon records a beacon's sender unless it is us or already known. Beacons are not
+// authenticated, so the sender is only added once a TLS session pinned to the NodeID it claims
+// succeeds at the address it came from. Even then the entry carries the oldest possible LastSeen:
+// it only holds an address until the node's own signed record arrives and replaces it.
+func handleLANBeacon(b lanBeacon, ip net.IP) {
+	if b.NodeID == "" || b.NodeID == nodeid.GetNodeID() || b.Port <= 0 || b.Port > 65535 {
+		return
+	}
+	if _, known := Peers().Get(b.NodeID); known {
+		return
+	}
+	if b.Protocol < MinProtocolVersion {
+		logger.Log("DEBUG", "lan", fmt.Sprintf("Ignoring %s: protocol v%d too old", b.NodeID, b.Protocol))
+		return
+	}
+	entry := PeerEntry{
+		NodeID:    b.NodeID,
+		Type:      "default",
+		IPv4:      ip.String(),
+		IPv6:      "none",
+		Port:      b.Port,
+		LastSeen:  hlc.Timestamp{}.RFC3339(),
+		PublicKey: b.PublicKey,
+	}
+	if requireSignedPeers() && !keyDerivedID(entry) {
+		logger.Log("WARN", "lan", fmt.Sprintf("Ignoring beacon from %s: key does not match NodeID", b.NodeID))
+		return
+	}
+	if _, busy := lanProbing.LoadOrStore(b.NodeID, true); busy {
+		return
+	}
+	go func() {
+		defer lanProbing.Delete(b.NodeID)
+		pc, err := dialNexus(net.JoinHostPort(entry.IPv4, fmt.Sprint(entry.Port)), entry.NodeID)
+		if err != nil {
+			logger.Log("DEBUG", "lan", fmt.Sprintf("Ignoring beacon from %s: %v", b.NodeID, err))
+			return
+		}
+		pc.Close()
+
+		if !Peers().Add(entry) {
+			return
+		}
+		dhtTable().Add(entry)
+		logger.Log("INFO", "lan", fmt.Sprintf("Discovered LAN peer %s at %s:%d", b.NodeID, entry.IPv4, entry.Port))
+	}()
+}
@@ -2,84 +2,379 @@ package p2p
 
 import (
     "bufio"
+    "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
     "net"
     "strings"
+    "sync"
     "time"
 
+    "atsuko-nexus/src/gossip"
+    "atsuko-nexus/src/kv"
+    "atsuko-nexus/src/hlc"
     "atsuko-nexus/src/logger"
     "atsuko-nexus/src/nodeid"
     "atsuko-nexus/src/settings"
+    "atsuko-nexus/src/tasks"
     "atsuko-nexus/src/types"
 )
 
-// StartNexusListener spins up your TCP listener and dispatches incoming connections.
-func StartNexusListener() {
+// NexusListener accepts peer connections on network.listen_port and tracks them so
+// shutdown can drain in-flight sessions before closing.
+type NexusListener struct {
+    mu     sync.Mutex
+    ln     net.Listener
+    conns  map[net.Conn]struct{}
+    wg     sync.WaitGroup
+    closed bool
+}
+
+// NewNexusListener returns a listener service; call Start to begin accepting.
+func NewNexusListener() *NexusListener {
+    return &NexusListener{conns: make(map[net.Conn]struct{})}
+}
+
+// Name identifies the service in lifecycle logs.
+func (l *NexusListener) Name() string { return "nexus listener" }
+
+// Start binds the TCP port and dispatches incoming connections in the background.
+func (l *NexusListener) Start(ctx context.Context) error {
     port := settings.Get("network.listen_port").(int)
     listenAddr := fmt.Sprintf("0.0.0.0:%d", port)
 
-    go func() {
-        ln, err := net.Listen("tcp", listenAddr)
-        if err != nil {
-            logger.Log("ERROR", "nexus", "Failed to start listener: "+err.Error())
-            return
-        }
-        logger.Log("INFO", "nexus", "Listening for connections on "+listenAddr)
+    ln, err := net.Listen("tcp", listenAddr)
+    if err != nil {
+        logger.Log("ERROR", "nexus", "Failed to start listener: "+err.Error())
+        return err
+    }
+    l.ln = ln
+    logger.Log("INFO", "nexus", "Listening for connections on "+listenAddr)
 
+    go func() {
         for {
             conn, err := ln.Accept()
             if err != nil {
+                l.mu.Lock()
+                closed := l.closed
+                l.mu.Unlock()
+                if closed {
+                    return
+                }
+                time.Sleep(50 * time.Millisecond)
                 continue
             }
-            go handleNexusConn(conn)
+            if !l.track(conn) {
+                conn.Close()
+                return
+            }
+            if ip := remoteIP(conn); Bans().IsIPBanned(ip) || !Limiter().AllowConn(ip) {
+                conn.Close()
+                l.untrack(conn)
+                continue
+            }
+            go func() {
+                defer l.untrack(conn)
+                handleNexusConn(conn)
+            }()
         }
     }()
+    return nil
 }
 
-// handleNexusConn processes either a PEERLIST or SYNC command.
+// Stop closes the listener and waits for in-flight sessions to finish. Sessions still open
+// when ctx expires are closed forcibly.
+func (l *NexusListener) Stop(ctx context.Context) error {
+    l.mu.Lock()
+    l.closed = true
+    l.mu.Unlock()
+    if l.ln != nil {
+        l.ln.Close()
+    }
+
+    drained := make(chan struct{})
+    go func() {
+        l.wg.Wait()
+        close(drained)
+    }()
+
+    select {
+    case <-drained:
+        return nil
+    case <-ctx.Done():
+        l.mu.Lock()
+        n := len(l.conns)
+        for c := range l.conns {
+            c.Close()
+        }
+        l.mu.Unlock()
+        logger.Log("WARN", "nexus", fmt.Sprintf("Closed %d peer sessions that did not drain in time", n))
+        <-drained
+        return ctx.Err()
+    }
+}
+
+// track registers an accepted connection unless the listener is shutting down.
+func (l *NexusListener) track(conn net.Conn) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.closed {
+        return false
+    }
+    l.conns[conn] = struct{}{}
+    l.wg.Add(1)
+    return true
+}
+
+func (l *NexusListener) untrack(conn net.Conn) {
+    l.mu.Lock()
+    delete(l.conns, conn)
+    l.mu.Unlock()
+    l.wg.Done()
+}
+
+// handleNexusConn sniffs the first bytes of a connection and hands TLS sessions to the framed
+// protocol handler, or plaintext commands to the legacy handler while compatibility mode is on.
 func handleNexusConn(conn net.Conn) {
     defer conn.Close()
     reader := bufio.NewReader(conn)
     conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 
-    // 1) Read incoming command
-    line, err := reader.ReadString('\n')
+    if isTLSHandshake(reader) {
+        cfg, err := tlsServerConfig()
+        if err != nil {
+            logger.Log("ERROR", "nexus", "TLS unavailable: "+err.Error())
+            return
+        }
+        tc := tls.Server(&bufferedConn{Conn: conn, rdr: reader}, cfg)
+        defer tc.Close()
+        handleFramedConn(tc)
+        return
+    }
+
+    if isFramed(reader) {
+        logger.Log("WARN", "nexus", fmt.Sprintf("Refusing unencrypted session from %s", conn.RemoteAddr()))
+        return
+    }
+
+    if settings.Get("network.legacy_plaintext") != true {
+        logger.Log("WARN", "nexus", fmt.Sprintf("Dropping unframed connection from %s (legacy mode off)", conn.RemoteAddr()))
+        return
+    }
+    handleLegacyConn(conn, reader)
+}
+
+// featureVersion is the lowest session version on which each request may be sent. Requests
+// not listed here have been part of the protocol since v1.
+var featureVersion = map[MsgType]uint8{
+    MsgFindNode:   versionFindNode,
+    MsgDigest:     versionAntiEntropy,
+    MsgGossip:     versionGossip,
+    MsgGossipPull: versionGossip,
+    MsgTaskSteal:  versionTasks,
+    MsgTaskReport: versionTasks,
+    MsgKVDigest:   versionKV,
+    MsgTrustSync:  versionTrust,
+}
+
+// handleFramedConn runs the TLS and HELLO handshakes and then serves requests until the peer hangs up.
+func handleFramedConn(conn *tls.Conn) {
+    pc, err := acceptNexus(conn)
     if err != nil {
+        logger.Log("WARN", "nexus", fmt.Sprintf("Handshake with %s failed: %v", conn.RemoteAddr(), err))
         return
     }
-    cmd := strings.TrimSpace(line)
+    logger.Log("DEBUG", "nexus", fmt.Sprintf("Session with %s (%s) on protocol v%d", pc.remoteID, conn.RemoteAddr(), pc.version))
+    Peers().MarkContacted(pc.remoteID)
+    Health().Succeeded(pc.remoteID)
 
-    // 2) Common values
-    peerPath := fmt.Sprint(settings.Get("storage.peer_cache_file"))
-    selfID   := nodeid.GetNodeID()
-    localType := types.NodeType()                               // <-- determine your node’s role once
+    for {
+        conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+        f, err := readFrame(pc.rdr)
+        if err != nil {
+            if !errors.Is(err, io.EOF) {
+                logger.Log("DEBUG", "nexus", fmt.Sprintf("Session with %s ended: %v", conn.RemoteAddr(), err))
+            }
+            return
+        }
 
-    // 3) Fetch our current public IPs
-    ipv4  := fetchPublicIP("https://api.ipify.org")
-    rawIP := fetchPublicIP("https://api64.ipify.org")
+        if !Limiter().AllowMessage(pc.remoteID) {
+            pc.sendError("rate limit exceeded")
+            return
+        }
+        if need := featureVersion[f.Type]; pc.version < need {
+            pc.sendError(fmt.Sprintf("%s needs protocol v%d, session is v%d", f.Type, need, pc.version))
+            return
+        }
 
-    ipv6 := "none"
-    if rawIP != ipv4 {
-        if parsed := net.ParseIP(rawIP); parsed != nil && parsed.To4() == nil {
-            ipv6 = parsed.String()
+        switch f.Type {
+        case MsgPeerListRequest:
+            peers := refreshSelfEntry()
+            if err := pc.send(MsgPeerList, peers); err != nil {
+                return
+            }
+
+        case MsgSync:
+            logger.Log("INFO", "tapsync", fmt.Sprintf("Sync requested from peer %s", conn.RemoteAddr()))
+            peers := refreshSelfEntry()
+
+            if err := pc.send(MsgPeerList, peers); err != nil {
+                return
+            }
+            logger.Log("INFO", "tapsync",
+                fmt.Sprintf("Sent %d peers to %s", len(peers), conn.RemoteAddr()),
+            )
+
+            var theirPeers []PeerEntry
+            if err := pc.recvMax(MsgPeerList, &theirPeers, maxPeerListBytes()); err != nil {
+                logger.Log("ERROR", "sync", "Failed to read incoming peers: "+err.Error())
+                if isInvalidPayload(err) {
+                    Reputations().RecordInvalid(pc.remoteID, err.Error())
+                }
+                return
+            }
+            if badOwnRecord(theirPeers, pc.remoteID) {
+                Reputations().RecordInvalid(pc.remoteID, "own record has a bad signature")
+            }
+
+            merged := Peers().Merge(capPeerList(theirPeers, pc.remoteID))
+            if err := pc.send(MsgSyncMerged, merged); err != nil {
+                return
+            }
+
+        case MsgDigest:
+            var digest peerDigest
+            if err := json.Unmarshal(f.Payload, &digest); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed DIGEST")
+                pc.sendError("malformed DIGEST")
+                return
+            }
+            logger.Log("DEBUG", "tapsync", fmt.Sprintf("Digest sync requested from peer %s", conn.RemoteAddr()))
+            if err := serveAntiEntropy(pc, digest); err != nil {
+                logger.Log("ERROR", "sync", fmt.Sprintf("Digest sync with %s failed: %v", pc.remoteID, err))
+                if isInvalidPayload(err) {
+                    Reputations().RecordInvalid(pc.remoteID, err.Error())
+                }
+                return
+            }
+
+        case MsgGossip:
+            node := gossip.Default()
+            if node == nil {
+                pc.sendError("gossip unavailable")
+                return
+            }
+            var msg gossip.Message
+            if len(f.Payload) > maxGossipBytes || json.Unmarshal(f.Payload, &msg) != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed GOSSIP")
+                return
+            }
+            handleGossipPush(node, pc.remoteID, msg)
+
+        case MsgGossipPull:
+            node := gossip.Default()
+            if node == nil {
+                pc.sendError("gossip unavailable")
+                return
+            }
+            var req gossipPullPayload
+            if err := json.Unmarshal(f.Payload, &req); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed GOSSIP-PULL")
+                return
+            }
+            if err := pc.send(MsgGossipMessages, node.HandlePull(req.Have)); err != nil {
+                return
+            }
+
+        case MsgKVDigest:
+            var digest kv.Digest
+            if err := json.Unmarshal(f.Payload, &digest); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed KV-DIGEST")
+                pc.sendError("malformed KV-DIGEST")
+                return
+            }
+            if err := serveKV(pc, digest); err != nil {
+                logger.Log("ERROR", "kv", fmt.Sprintf("KV sync with %s failed: %v", pc.remoteID, err))
+                if isInvalidPayload(err) {
+                    Reputations().RecordInvalid(pc.remoteID, err.Error())
+                }
+                return
+            }
+
+        case MsgTrustSync:
+            var req trustSyncPayload
+            if err := json.Unmarshal(f.Payload, &req); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed TRUST-SYNC")
+                pc.sendError("malformed TRUST-SYNC")
+                return
+            }
+            if err := serveTrust(pc, req); err != nil {
+                logger.Log("ERROR", "admin", fmt.Sprintf("Trust sync with %s failed: %v", pc.remoteID, err))
+                if isInvalidPayload(err) {
+                    Reputations().RecordInvalid(pc.remoteID, err.Error())
+                }
+                return
+            }
+
+        case MsgTaskSteal:
+            var req taskStealPayload
+            if err := json.Unmarshal(f.Payload, &req); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed TASK-STEAL")
+                pc.sendError("malformed TASK-STEAL")
+                return
+            }
+            if err := serveTaskSteal(pc, req); err != nil {
+                return
+            }
+
+        case MsgTaskReport:
+            var r tasks.Report
+            if err := json.Unmarshal(f.Payload, &r); err != nil {
+                Reputations().RecordInvalid(pc.remoteID, "malformed TASK-REPORT")
+                pc.sendError("malformed TASK-REPORT")
+                return
+            }
+            if err := serveTaskReport(pc, r); err != nil {
+                return
+            }
+
+        case MsgFindNode:
+            var req findNodePayload
+            if err := json.Unmarshal(f.Payload, &req); err != nil {
+                pc.sendError("malformed FIND_NODE")
+                return
+            }
+            nodes := dhtTable().Closest(toDHTID(req.Target), dhtK)
+            if err := pc.send(MsgNodes, nodes); err != nil {
+                return
+            }
+
+        default:
+            pc.sendError("unsupported message " + f.Type.String())
+            return
         }
     }
-    port := settings.Get("network.listen_port").(int)
+}
+
+// handleLegacyConn answers the pre-framing newline-terminated PEERLIST and SYNC commands.
+// It is kept for one release so older nodes can still reach us, and only runs when
+// network.legacy_plaintext is switched on: these commands are unauthenticated.
+func handleLegacyConn(conn net.Conn, reader *bufio.Reader) {
+    // 1) Read incoming command
+    line, err := readLineMax(reader, 64)
+    if err != nil {
+        return
+    }
+    cmd := strings.TrimSpace(line)
+    logger.Log("DEBUG", "nexus", fmt.Sprintf("Legacy %q command from %s", cmd, conn.RemoteAddr()))
+
 
     switch cmd {
     case "PEERLIST":
-        peers := loadPeers(peerPath)
-        for i := range peers {
-            if peers[i].NodeID == selfID {
-                peers[i].Type     = localType                      // <-- update Type
-                peers[i].IPv4     = ipv4
-                peers[i].IPv6     = ipv6
-                peers[i].Port     = port
-                peers[i].LastSeen = time.Now().UTC().Format(time.RFC3339)
-            }
-        }
-        savePeers(peerPath, peers)
+        peers := refreshSelfEntry()
 
         data, _ := json.Marshal(peers)
         conn.Write(data)
@@ -89,17 +384,7 @@ func handleNexusConn(conn net.Conn) {
         logger.Log("INFO", "tapsync", fmt.Sprintf("Sync requested from peer %s", conn.RemoteAddr()))
 
         // 1) Update our own entry before sending
-        peers := loadPeers(peerPath)
-        for i := range peers {
-            if peers[i].NodeID == selfID {
-                peers[i].Type     = localType                      // <-- update Type
-                peers[i].LastSeen = time.Now().UTC().Format(time.RFC3339)
-                peers[i].IPv4     = ipv4
-                peers[i].IPv6     = ipv6
-                peers[i].Port     = port
-            }
-        }
-        savePeers(peerPath, peers)
+        peers := refreshSelfEntry()
 
         // 2) Send out our list
         out, _ := json.Marshal(peers)
@@ -110,7 +395,7 @@ func handleNexusConn(conn net.Conn) {
         )
 
         // 3) Read their list back
-        incoming, err := reader.ReadString('\n')
+        incoming, err := readLineMax(reader, maxPeerListBytes())
         if err != nil {
             logger.Log("ERROR", "sync", "Failed to read incoming peers: "+err.Error())
             return
@@ -122,8 +407,7 @@ func handleNexusConn(conn net.Conn) {
         }
 
         // 4) Merge—including each peer’s Type from their payload—and persist
-        merged := mergePeers(peers, theirPeers)
-        savePeers(peerPath, merged)
+        merged := Peers().Merge(capPeerList(theirPeers, conn.RemoteAddr().String()))
 
         resp, _ := json.Marshal(merged)
         conn.Write(resp)
@@ -133,3 +417,32 @@ func handleNexusConn(conn net.Conn) {
         return
     }
 }
+
+// refreshSelfEntry updates our own record (role, public IPs, port, LastSeen) in the peer store
+// and returns the full peer list.
+func refreshSelfEntry() []PeerEntry {
+    selfID    := nodeid.GetNodeID()
+    localType := types.NodeType()
+
+    ipv4  := fetchPublicIP("https://api.ipify.org")
+    rawIP := fetchPublicIP("https://api64.ipify.org")
+
+    ipv6 := "none"
+    if rawIP != ipv4 {
+        if parsed := net.ParseIP(rawIP); parsed != nil && parsed.To4() == nil {
+            ipv6 = parsed.String()
+        }
+    }
+    port := settings.Get("network.listen_port").(int)
+
+    store := Peers()
+    store.Update(selfID, func(p *PeerEntry) {
+        stampRole(p, localType)
+        p.IPv4     = ipv4
+        p.IPv6     = ipv6
+        p.Port     = port
+        p.LastSeen = hlc.Default().Now().RFC3339()
+        signSelfEntry(p)
+    })
+    return store.List()
+}
@@ -1,33 +1,20 @@
 package p2p
 
 import (
-	"os"
+	"bufio"
+	"fmt"
 	"time"
-	"strings"
 
+	"atsuko-nexus/src/logger"
 	"atsuko-nexus/src/settings"
-	"gopkg.in/yaml.v3"
 )
 
-// CountActivePeers returns how many peers have been seen within the last 30 minutes.
+// CountActivePeers returns how many peers (excluding ourselves) have been seen within the last 60 minutes.
 func CountActivePeers() int {
-	exePath, _ := os.Executable()
-	peerPath := exePath[:strings.LastIndex(exePath, "/")+1] + settings.Get("storage.peer_cache_file").(string)
-
-	data, err := os.ReadFile(peerPath)
-	if err != nil {
-		return 0
-	}
-
-	var pf PeerFile
-	if err := yaml.Unmarshal(data, &pf); err != nil {
-		return 0
-	}
-
 	count := -1
 	cutoff := time.Now().Add(-60 * time.Minute)
-	for _, peer := range pf.Peers {
-		if ts, err := time.Parse(time.RFC3339, peer.LastSeen); err == nil {
+	for _, peer := range Peers().List() {
+		if ts, ok := lastSeen(peer); ok {
 			if ts.After(cutoff) {
 				count++
 			}
@@ -36,3 +23,49 @@ func CountActivePeers() int {
 
 	return count
 }
+
+// maxPeerRecordBytes is a generous upper bound for one JSON-encoded PeerEntry on the wire.
+const maxPeerRecordBytes = 2048
+
+// maxPeers returns network.max_peers, the most peers (besides ourselves) the store keeps.
+func maxPeers() int {
+	if n, ok := settings.Get("network.max_peers").(int); ok && n > 0 {
+		return n
+	}
+	return 100
+}
+
+// maxPeerListBytes bounds an incoming peer list so an oversized SYNC is refused before it is decoded.
+func maxPeerListBytes() int {
+	limit := (maxPeers() + 1) * maxPeerRecordBytes
+	if limit > maxFrameSize {
+		return maxFrameSize
+	}
+	return limit
+}
+
+// readLineMax reads a newline-terminated line of at most limit bytes.
+func readLineMax(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", fmt.Errorf("line exceeds %d bytes", limit)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return string(line), err
+	}
+}
+
+// capPeerList drops entries beyond what a single peer may legitimately send us.
+func capPeerList(peers []PeerEntry, from string) []PeerEntry {
+	limit := maxPeers() + 1
+	if len(peers) <= limit {
+		return peers
+	}
+	logger.Log("WARN", "peers", fmt.Sprintf("Peer list from %s has %d entries; keeping the first %d", from, len(peers), limit))
+	return peers[:limit]
+}
@@ -0,0 +1,156 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/settings"
+	"atsuko-nexus/src/types"
+)
+
+const (
+	// metaLegacyID records the hardware NodeID we ran under before key mode and, after a space,
+	// until when to keep announcing it.
+	metaLegacyID = "legacy_id"
+	// legacyAnnounceFor is how long a migrated node names its old NodeID in its record, long enough
+	// for peers that were offline at the time to map old to new.
+	legacyAnnounceFor = 30 * 24 * time.Hour
+)
+
+var (
+	errUnsigned     = errors.New("peer record is not signed")
+	errBadSignature = errors.New("peer record signature is invalid")
+	errKeyMismatch  = errors.New("peer record key does not match the key known for its NodeID")
+)
+
+// signingBytes returns the canonical form of the fields covered by a peer record signature.
+func (p PeerEntry) signingBytes() []byte {
+	return []byte(strings.Join([]string{
+		p.NodeID,
+		p.Type,
+		p.IPv4,
+		p.IPv6,
+		strconv.Itoa(p.Port),
+		p.LastSeen,
+		p.LegacyID,
+		p.PublicKey,
+	}, "\n"))
+}
+
+// signSelfEntry stamps our public key on p and signs it with the local identity.
+// It must be called after every change to our own record.
+func signSelfEntry(p *PeerEntry) {
+	id := types.LocalIdentity()
+	if id == nil {
+		return
+	}
+	p.PublicKey = id.PublicHex()
+	p.Signature = hex.EncodeToString(id.Sign(p.signingBytes()))
+}
+
+// verifyPeerEntry checks that p carries a valid signature made by its own public key.
+func verifyPeerEntry(p PeerEntry) error {
+	if p.PublicKey == "" || p.Signature == "" {
+		return errUnsigned
+	}
+	pub, err := hex.DecodeString(p.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("peer record has malformed public key")
+	}
+	sig, err := hex.DecodeString(p.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errBadSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), p.signingBytes(), sig) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// requireSignedPeers reports whether identity.require_signed_peers is enabled.
+func requireSignedPeers() bool {
+	return settings.Get("identity.require_signed_peers") == true
+}
+
+// keyDerivedID reports whether p's NodeID is the hash of its own public key.
+func keyDerivedID(p PeerEntry) bool {
+	pub, err := hex.DecodeString(p.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	return nodeid.FromPublicKey(pub) == p.NodeID
+}
+
+// admitPeer decides whether an incoming record may replace or join our view of the network.
+// With identity.require_signed_peers on, it must be validly signed and its key must match its
+// NodeID: either the NodeID is derived from the key, or (for hardware-mode nodes) the key is
+// the one we first saw for that NodeID.
+func admitPeer(existing *PeerEntry, inc PeerEntry) error {
+	if !requireSignedPeers() {
+		return nil
+	}
+	if err := verifyPeerEntry(inc); err != nil {
+		return err
+	}
+	if keyDerivedID(inc) {
+		return nil
+	}
+	if existing != nil && existing.PublicKey != "" && existing.PublicKey != inc.PublicKey {
+		return errKeyMismatch
+	}
+	return nil
+}
+
+// NoteMigration records our old hardware NodeID when this run derives a new one from the identity
+// key. prev is the NodeID stored by the last run; installs from before it was stored are matched by
+// our own record under the hardware ID in the imported peer cache. Fresh installs record nothing,
+// so they never compute or announce a machine fingerprint. Call it once the database is open and
+// before the current NodeID is stored.
+func NoteMigration(prev, cur string) error {
+	if nodeid.Mode() != nodeid.ModeKey || prev == cur {
+		return nil
+	}
+	if prev == "" && Peers().Len() == 0 {
+		return nil
+	}
+	hw := nodeid.HardwareID()
+	if hw == cur {
+		return nil
+	}
+	if _, cached := Peers().Get(hw); prev != hw && !(prev == "" && cached) {
+		return nil
+	}
+	until := time.Now().Add(legacyAnnounceFor).UTC().Format(time.RFC3339)
+	logger.Log("INFO", "peers", fmt.Sprintf("Migrated from hardware NodeID %s; announcing it until %s", hw, until))
+	return db.SetMeta(metaLegacyID, hw+" "+until)
+}
+
+// legacyID returns the hardware NodeID recorded by NoteMigration while it is still announced, or "".
+func legacyID() string {
+	old, until, ok := strings.Cut(db.GetMeta(metaLegacyID), " ")
+	if !ok {
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339, until); err != nil || time.Now().After(t) {
+		return ""
+	}
+	return old
+}
+
+// canRetireLegacy reports whether a signed, key-derived record may replace the entry stored
+// under its old hardware ID. The old entry must carry a valid signature by the same key; anyone
+// can name an arbitrary LegacyID, so an unsigned entry is left to age out on its own.
+func canRetireLegacy(old, inc PeerEntry) bool {
+	if verifyPeerEntry(inc) != nil || !keyDerivedID(inc) {
+		return false
+	}
+	return old.PublicKey == inc.PublicKey && verifyPeerEntry(old) == nil
+}
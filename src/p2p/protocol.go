@@ -0,0 +1,379 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"atsuko-nexus/src/nodeid"
+)
+
+// Every framed message on the Nexus port starts with a fixed header:
+//
+//	magic   [4]byte  "ATSN"
+//	version uint8    protocol version the frame is encoded with
+//	type    uint8    MsgType
+//	length  uint32   big-endian payload length
+//	payload [length]byte (JSON)
+const (
+	frameHeaderSize = 10
+	maxFrameSize    = 4 << 20 // 4 MiB
+
+	// ProtocolVersion is the highest wire protocol version this build speaks.
+	ProtocolVersion uint8 = 8
+	// MinProtocolVersion is the oldest wire protocol version this build still accepts.
+	MinProtocolVersion uint8 = 1
+)
+
+var frameMagic = [4]byte{'A', 'T', 'S', 'N'}
+
+// MsgType identifies the payload carried by a frame.
+type MsgType uint8
+
+const (
+	MsgHello MsgType = iota + 1
+	MsgHelloAck
+	MsgError
+	MsgPeerListRequest
+	MsgPeerList
+	MsgSync
+	MsgSyncMerged
+	MsgFindNode
+	MsgNodes
+	MsgDigest
+	MsgPeerDelta
+	MsgGossip
+	MsgGossipPull
+	MsgGossipMessages
+	MsgTaskSteal
+	MsgTaskLeases
+	MsgTaskReport
+	MsgTaskReportAck
+	MsgKVDigest
+	MsgKVDelta
+	MsgTrustSync
+	MsgTrustLog
+)
+
+// String returns a readable name for logging.
+func (t MsgType) String() string {
+	switch t {
+	case MsgHello:
+		return "HELLO"
+	case MsgHelloAck:
+		return "HELLO-ACK"
+	case MsgError:
+		return "ERROR"
+	case MsgPeerListRequest:
+		return "PEERLIST-REQ"
+	case MsgPeerList:
+		return "PEERLIST"
+	case MsgSync:
+		return "SYNC"
+	case MsgSyncMerged:
+		return "SYNC-MERGED"
+	case MsgFindNode:
+		return "FIND_NODE"
+	case MsgNodes:
+		return "NODES"
+	case MsgDigest:
+		return "DIGEST"
+	case MsgPeerDelta:
+		return "DELTA"
+	case MsgGossip:
+		return "GOSSIP"
+	case MsgGossipPull:
+		return "GOSSIP-PULL"
+	case MsgGossipMessages:
+		return "GOSSIP-MSGS"
+	case MsgTaskSteal:
+		return "TASK-STEAL"
+	case MsgTaskLeases:
+		return "TASK-LEASES"
+	case MsgTaskReport:
+		return "TASK-REPORT"
+	case MsgTaskReportAck:
+		return "TASK-REPORT-ACK"
+	case MsgKVDigest:
+		return "KV-DIGEST"
+	case MsgKVDelta:
+		return "KV-DELTA"
+	case MsgTrustSync:
+		return "TRUST-SYNC"
+	case MsgTrustLog:
+		return "TRUST-LOG"
+	default:
+		return fmt.Sprintf("MSG(%d)", uint8(t))
+	}
+}
+
+// Frame is a single decoded message.
+type Frame struct {
+	Version uint8
+	Type    MsgType
+	Payload []byte
+}
+
+// helloPayload is sent by the dialing side to open a session.
+type helloPayload struct {
+	NodeID     string `json:"node_id"`
+	MinVersion uint8  `json:"min_version"`
+	MaxVersion uint8  `json:"max_version"`
+	// Time is the sender's wall clock (RFC3339Nano), used to measure clock skew. Older nodes omit it.
+	Time string `json:"time,omitempty"`
+}
+
+// helloAckPayload carries the version both sides agreed on.
+type helloAckPayload struct {
+	NodeID  string `json:"node_id"`
+	Version uint8  `json:"version"`
+	Time    string `json:"time,omitempty"`
+}
+
+// errorPayload is sent before closing a session that cannot continue.
+type errorPayload struct {
+	Message string `json:"message"`
+}
+
+var (
+	errBadMagic        = errors.New("bad frame magic")
+	errFrameTooLarge   = errors.New("frame too large")
+	errUnexpectedFrame = errors.New("unexpected frame")
+)
+
+// writeFrame encodes and writes a single frame.
+func writeFrame(w io.Writer, f Frame) error {
+	if len(f.Payload) > maxFrameSize {
+		return fmt.Errorf("frame too large: %d bytes", len(f.Payload))
+	}
+	var hdr [frameHeaderSize]byte
+	copy(hdr[:4], frameMagic[:])
+	hdr[4] = f.Version
+	hdr[5] = uint8(f.Type)
+	binary.BigEndian.PutUint32(hdr[6:], uint32(len(f.Payload)))
+
+	buf := make([]byte, 0, frameHeaderSize+len(f.Payload))
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, f.Payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads a single frame, rejecting bad magic and oversized payloads before allocating.
+func readFrame(r io.Reader) (Frame, error) {
+	return readFrameMax(r, maxFrameSize)
+}
+
+// readFrameMax is readFrame with a caller-chosen payload limit, checked against the header
+// before any payload byte is read or decoded.
+func readFrameMax(r io.Reader, limit int) (Frame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Frame{}, err
+	}
+	if !bytes.Equal(hdr[:4], frameMagic[:]) {
+		return Frame{}, errBadMagic
+	}
+	length := binary.BigEndian.Uint32(hdr[6:])
+	if int64(length) > int64(limit) {
+		return Frame{}, fmt.Errorf("%w: %s is %d bytes (limit %d)", errFrameTooLarge, MsgType(hdr[5]), length, limit)
+	}
+	f := Frame{Version: hdr[4], Type: MsgType(hdr[5])}
+	if length > 0 {
+		f.Payload = make([]byte, length)
+		if _, err := io.ReadFull(r, f.Payload); err != nil {
+			return Frame{}, err
+		}
+	}
+	return f, nil
+}
+
+// isFramed reports whether the buffered connection starts with the frame magic.
+// Framed traffic is only accepted inside TLS; plaintext frames are refused.
+func isFramed(rdr *bufio.Reader) bool {
+	head, err := rdr.Peek(len(frameMagic))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(head, frameMagic[:])
+}
+
+// peerConn is an established, authenticated and version-negotiated session with a remote node.
+type peerConn struct {
+	conn      net.Conn
+	rdr       *bufio.Reader
+	version   uint8
+	remoteID  string
+	remoteKey ed25519.PublicKey
+}
+
+// send JSON-encodes v and writes it as a frame of type t.
+func (pc *peerConn) send(t MsgType, v any) error {
+	var payload []byte
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		payload = data
+	}
+	return writeFrame(pc.conn, Frame{Version: pc.version, Type: t, Payload: payload})
+}
+
+// recv reads the next frame, requires it to be of type t and decodes it into v.
+// A MsgError frame from the remote is returned as an error.
+func (pc *peerConn) recv(t MsgType, v any) error {
+	return pc.recvMax(t, v, maxFrameSize)
+}
+
+// recvMax is recv with a payload size limit enforced before decoding.
+func (pc *peerConn) recvMax(t MsgType, v any, limit int) error {
+	f, err := readFrameMax(pc.rdr, limit)
+	if err != nil {
+		return err
+	}
+	if f.Type == MsgError {
+		var e errorPayload
+		_ = json.Unmarshal(f.Payload, &e)
+		return fmt.Errorf("remote error: %s", e.Message)
+	}
+	if f.Type != t {
+		return fmt.Errorf("%w: got %s, wanted %s", errUnexpectedFrame, f.Type, t)
+	}
+	if v == nil || len(f.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(f.Payload, v)
+}
+
+// sendError tells the remote why the session is being closed.
+func (pc *peerConn) sendError(msg string) {
+	_ = pc.send(MsgError, errorPayload{Message: msg})
+}
+
+// Close closes the underlying connection.
+func (pc *peerConn) Close() error {
+	return pc.conn.Close()
+}
+
+// negotiateVersion picks the highest version both ranges share.
+func negotiateVersion(remoteMin, remoteMax uint8) (uint8, bool) {
+	v := ProtocolVersion
+	if remoteMax < v {
+		v = remoteMax
+	}
+	if v < MinProtocolVersion || v < remoteMin {
+		return 0, false
+	}
+	return v, true
+}
+
+// dialNexus connects to addr over TLS and performs the HELLO/HELLO-ACK exchange.
+// When expectedID is set, the session is refused unless the remote proves that identity.
+// Banned NodeIDs and addresses are refused before any connection is made.
+func dialNexus(addr, expectedID string) (*peerConn, error) {
+	if Bans().IsNodeBanned(expectedID) {
+		return nil, fmt.Errorf("%w: %s", errPeerBanned, expectedID)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && Bans().IsIPBanned(host) {
+		return nil, fmt.Errorf("%w: %s", errPeerBanned, host)
+	}
+	cfg, err := tlsClientConfig(expectedID)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(time.Now().Add(10 * time.Second))
+
+	conn := tls.Client(raw, cfg)
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+
+	pc := &peerConn{conn: conn, rdr: bufio.NewReader(conn), version: MinProtocolVersion, remoteKey: peerKey(conn)}
+	hello := helloPayload{
+		NodeID:     nodeid.GetNodeID(),
+		MinVersion: MinProtocolVersion,
+		MaxVersion: ProtocolVersion,
+		Time:       handshakeTime(),
+	}
+	sent := time.Now()
+	if err := pc.send(MsgHello, hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var ack helloAckPayload
+	if err := pc.recv(MsgHelloAck, &ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s failed: %w", addr, err)
+	}
+	if expectedID != "" && ack.NodeID != expectedID {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s answered as %s, expected %s", errIdentityMismatch, addr, ack.NodeID, expectedID)
+	}
+	if !identityMatches(ack.NodeID, pc.remoteKey) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s claims %s", errIdentityMismatch, addr, ack.NodeID)
+	}
+	if Bans().IsNodeBanned(ack.NodeID) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", errPeerBanned, ack.NodeID)
+	}
+	if ack.Version < MinProtocolVersion || ack.Version > ProtocolVersion {
+		conn.Close()
+		return nil, fmt.Errorf("peer %s chose unsupported protocol version %d", addr, ack.Version)
+	}
+	pc.version = ack.Version
+	pc.remoteID = ack.NodeID
+	recordHandshakeSkew(ack.NodeID, ack.Time, sent.Add(time.Since(sent)/2))
+	return pc, nil
+}
+
+// acceptNexus completes the TLS handshake on an incoming connection and answers its HELLO.
+// The NodeID named in HELLO must match the certificate key the client presented.
+func acceptNexus(conn *tls.Conn) (*peerConn, error) {
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	pc := &peerConn{conn: conn, rdr: bufio.NewReader(conn), version: MinProtocolVersion, remoteKey: peerKey(conn)}
+
+	var hello helloPayload
+	if err := pc.recv(MsgHello, &hello); err != nil {
+		return nil, err
+	}
+	if !identityMatches(hello.NodeID, pc.remoteKey) {
+		pc.sendError("identity does not match NodeID")
+		return nil, fmt.Errorf("%w: %s claims %s", errIdentityMismatch, conn.RemoteAddr(), hello.NodeID)
+	}
+	if Bans().IsNodeBanned(hello.NodeID) {
+		pc.sendError("banned")
+		return nil, fmt.Errorf("%w: %s", errPeerBanned, hello.NodeID)
+	}
+	v, ok := negotiateVersion(hello.MinVersion, hello.MaxVersion)
+	if !ok {
+		pc.sendError(fmt.Sprintf("no common protocol version (ours %d-%d, yours %d-%d)",
+			MinProtocolVersion, ProtocolVersion, hello.MinVersion, hello.MaxVersion))
+		return nil, fmt.Errorf("no common protocol version with %s", conn.RemoteAddr())
+	}
+
+	pc.version = v
+	pc.remoteID = hello.NodeID
+	recordHandshakeSkew(hello.NodeID, hello.Time, time.Now())
+	if err := pc.send(MsgHelloAck, helloAckPayload{NodeID: nodeid.GetNodeID(), Version: v, Time: handshakeTime()}); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
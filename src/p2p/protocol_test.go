@@ -0,0 +1,47 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadFrameMax(t *testing.T) {
+	var buf bytes.Buffer
+	payload := bytes.Repeat([]byte{'x'}, 100)
+	if err := writeFrame(&buf, Frame{Version: ProtocolVersion, Type: MsgGossip, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	f, err := readFrameMax(bytes.NewReader(raw), len(payload))
+	if err != nil {
+		t.Fatalf("frame at the limit refused: %v", err)
+	}
+	if f.Type != MsgGossip || !bytes.Equal(f.Payload, payload) {
+		t.Errorf("read back %s with %d bytes", f.Type, len(f.Payload))
+	}
+	if _, err := readFrameMax(bytes.NewReader(raw), len(payload)-1); !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("oversize frame: %v, want errFrameTooLarge", err)
+	}
+
+	// Only a header claiming a huge payload: refused from the header, without waiting for the body.
+	hdr := append([]byte(nil), raw[:frameHeaderSize]...)
+	binary.BigEndian.PutUint32(hdr[6:], 1<<31)
+	if _, err := readFrame(bytes.NewReader(hdr)); !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("header claiming 2 GiB: %v, want errFrameTooLarge", err)
+	}
+
+	bad := append([]byte("XXXX"), raw[4:]...)
+	if _, err := readFrame(bytes.NewReader(bad)); !errors.Is(err, errBadMagic) {
+		t.Errorf("bad magic: %v, want errBadMagic", err)
+	}
+	if _, err := readFrame(bytes.NewReader(raw[:frameHeaderSize+10])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated payload: %v, want io.ErrUnexpectedEOF", err)
+	}
+	if err := writeFrame(io.Discard, Frame{Payload: make([]byte, maxFrameSize+1)}); err == nil {
+		t.Error("writeFrame sent a frame over maxFrameSize")
+	}
+}
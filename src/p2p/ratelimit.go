@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// tokenBucket refills continuously at rate tokens per second up to capacity.
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+	cooldown time.Time // requests are refused until this moment after a limit hit
+}
+
+// RateLimiter hands out tokens per key ("ip:<addr>" or "node:<id>") and puts keys that run
+// dry into a cooldown. It is shared by every session on the listener.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	allowed atomic.Uint64
+	limited atomic.Uint64
+}
+
+// RateLimitStats is a snapshot of the limiter counters for display.
+type RateLimitStats struct {
+	Allowed uint64
+	Limited uint64
+	Cooling int
+	Tracked int
+}
+
+var (
+	limiter     *RateLimiter
+	limiterOnce sync.Once
+)
+
+// Limiter returns the process-wide rate limiter.
+func Limiter() *RateLimiter {
+	limiterOnce.Do(func() {
+		limiter = &RateLimiter{buckets: make(map[string]*tokenBucket)}
+	})
+	return limiter
+}
+
+// GetRateLimitStats returns the current limiter counters.
+func GetRateLimitStats() RateLimitStats {
+	return Limiter().Stats()
+}
+
+// remoteIP returns the host part of a connection's remote address.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// limitSetting reads an integer from limits.* with a fallback for missing or invalid values.
+func limitSetting(key string, def int) int {
+	if n, ok := settings.Get("limits." + key).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
+
+// AllowConn charges one token to the remote IP for a new connection. The bucket holds
+// limits.rate_limit_per_minute tokens and refills at the same rate per minute.
+func (rl *RateLimiter) AllowConn(ip string) bool {
+	perMin := limitSetting("rate_limit_per_minute", 60)
+	return rl.allow("ip:"+ip, float64(perMin), float64(perMin)/60)
+}
+
+// AllowMessage charges one token to an authenticated NodeID for a request frame. Bursts of up
+// to limits.max_messages_per_peer are allowed; the sustained rate is rate_limit_per_minute.
+func (rl *RateLimiter) AllowMessage(nodeID string) bool {
+	burst := limitSetting("max_messages_per_peer", 100)
+	perMin := limitSetting("rate_limit_per_minute", 60)
+	return rl.allow("node:"+nodeID, float64(burst), float64(perMin)/60)
+}
+
+// allow takes a token from key's bucket, starting a cooldown of limits.cooldown_on_limit_hit
+// seconds when the bucket is empty.
+func (rl *RateLimiter) allow(key string, capacity, rate float64) bool {
+	now := time.Now()
+
+	rl.mu.Lock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		rl.buckets[key] = b
+	}
+	b.capacity, b.rate = capacity, rate
+
+	if now.Before(b.cooldown) {
+		rl.mu.Unlock()
+		rl.limited.Add(1)
+		logger.Log("DEBUG", "nexus", fmt.Sprintf("Refused %s (cooling down)", key))
+		return false
+	}
+
+	b.refill(now)
+
+	if b.tokens < 1 {
+		cooldown := time.Duration(limitSetting("cooldown_on_limit_hit", 10)) * time.Second
+		b.cooldown = now.Add(cooldown)
+		rl.mu.Unlock()
+		rl.limited.Add(1)
+		logger.Log("WARN", "nexus", fmt.Sprintf("Rate limit hit for %s; cooling down for %s", key, cooldown))
+		return false
+	}
+	b.tokens--
+	rl.mu.Unlock()
+	rl.allowed.Add(1)
+	return true
+}
+
+// refill adds the tokens earned since the last call, up to capacity.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Prune forgets buckets that are full again and out of cooldown so the map does not grow
+// with every address that ever connected.
+func (rl *RateLimiter) Prune() {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for key, b := range rl.buckets {
+		if now.Before(b.cooldown) {
+			continue
+		}
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// Stats returns the limiter counters and how many keys are currently cooling down.
+func (rl *RateLimiter) Stats() RateLimitStats {
+	now := time.Now()
+
+	rl.mu.Lock()
+	cooling := 0
+	for _, b := range rl.buckets {
+		if now.Before(b.cooldown) {
+			cooling++
+		}
+	}
+	tracked := len(rl.buckets)
+	rl.mu.Unlock()
+
+	return RateLimitStats{
+		Allowed: rl.allowed.Load(),
+		Limited: rl.limited.Load(),
+		Cooling: cooling,
+		Tracked: tracked,
+	}
+}
@@ -0,0 +1,224 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/settings"
+)
+
+// Score adjustments per observed outcome. Scores are clamped to [0, 100].
+const (
+	scoreSuccess     = 2.0
+	scoreFastBonus   = 1.0 // extra credit for a sync that completed under fastLatency
+	scoreInvalid     = -15.0
+	scoreMax         = 100.0
+	fastLatency      = 250 * time.Millisecond
+	latencySmoothing = 0.2 // weight of the newest sample in the moving latency average
+)
+
+// Reputation is what we have observed about a single peer.
+type Reputation struct {
+	NodeID     string  `json:"node_id"`
+	Score      float64 `json:"score"`
+	Successes  int     `json:"successes"`
+	Failures   int     `json:"failures"`
+	Invalid    int     `json:"invalid"`
+	LatencyMs  float64 `json:"latency_ms"`
+	LastUpdate string  `json:"last_update"`
+}
+
+// ReputationBook tracks per-peer reputation, persists it to the reputation bucket and bans
+// peers whose score falls below reputation.ban_threshold.
+type ReputationBook struct {
+	mu     sync.Mutex
+	scores map[string]*Reputation
+}
+
+var (
+	reputation     *ReputationBook
+	reputationOnce sync.Once
+)
+
+// Reputations returns the process-wide reputation book, loading it from the database on first use.
+func Reputations() *ReputationBook {
+	reputationOnce.Do(func() {
+		reputation = &ReputationBook{scores: make(map[string]*Reputation)}
+		if _, err := db.Open(); err != nil {
+			logger.Log("ERROR", "peers", "Reputation running in memory only: "+err.Error())
+			return
+		}
+		err := db.ForEach(db.BucketReputation, func(_ string, value []byte) error {
+			var r Reputation
+			if err := json.Unmarshal(value, &r); err == nil && r.NodeID != "" {
+				reputation.scores[r.NodeID] = &r
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Log("ERROR", "peers", "Failed to load reputation: "+err.Error())
+		}
+	})
+	return reputation
+}
+
+// initialScore is the score a peer starts with, and returns to once a ban expires.
+func initialScore() float64 {
+	if n, ok := settings.Get("reputation.initial_score").(int); ok && n > 0 {
+		return float64(n)
+	}
+	return 50
+}
+
+// banThreshold is the score below which a peer is banned automatically.
+func banThreshold() float64 {
+	if n, ok := settings.Get("reputation.ban_threshold").(int); ok && n >= 0 {
+		return float64(n)
+	}
+	return 10
+}
+
+// Score returns nodeID's current score; unknown peers have the initial score.
+func (rb *ReputationBook) Score(nodeID string) float64 {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if r, ok := rb.scores[nodeID]; ok {
+		return r.Score
+	}
+	return initialScore()
+}
+
+// Get returns a copy of nodeID's reputation record.
+func (rb *ReputationBook) Get(nodeID string) (Reputation, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if r, ok := rb.scores[nodeID]; ok {
+		return *r, true
+	}
+	return Reputation{}, false
+}
+
+// RecordSuccess credits a completed exchange and folds its latency into the moving average.
+func (rb *ReputationBook) RecordSuccess(nodeID string, latency time.Duration) {
+	rb.record(nodeID, func(r *Reputation) {
+		r.Successes++
+		r.Score += scoreSuccess
+		if latency > 0 && latency < fastLatency {
+			r.Score += scoreFastBonus
+		}
+		ms := float64(latency) / float64(time.Millisecond)
+		if r.LatencyMs == 0 {
+			r.LatencyMs = ms
+		} else {
+			r.LatencyMs += latencySmoothing * (ms - r.LatencyMs)
+		}
+	}, "")
+}
+
+// RecordFailure counts an unreachable peer or a timed-out exchange. It does not lower the score:
+// being offline is not misbehaviour, and the reconnect backoff already deals with dead peers.
+func (rb *ReputationBook) RecordFailure(nodeID string) {
+	rb.record(nodeID, func(r *Reputation) {
+		r.Failures++
+	}, "")
+}
+
+// RecordInvalid penalises a peer that sent malformed data or bad signatures.
+func (rb *ReputationBook) RecordInvalid(nodeID, reason string) {
+	logger.Log("WARN", "peers", fmt.Sprintf("Invalid data from %s: %s", nodeID, reason))
+	rb.record(nodeID, func(r *Reputation) {
+		r.Invalid++
+		r.Score += scoreInvalid
+	}, reason)
+}
+
+// record applies fn to nodeID's record, persists it and bans the peer if its score dropped
+// below the threshold. The score is reset after a ban so the peer starts over when it expires.
+func (rb *ReputationBook) record(nodeID string, fn func(*Reputation), banReason string) {
+	if nodeID == "" {
+		return
+	}
+	rb.mu.Lock()
+	r, ok := rb.scores[nodeID]
+	if !ok {
+		r = &Reputation{NodeID: nodeID, Score: initialScore()}
+		rb.scores[nodeID] = r
+	}
+	fn(r)
+	r.Score = math.Max(0, math.Min(scoreMax, r.Score))
+	r.LastUpdate = time.Now().UTC().Format(time.RFC3339)
+
+	banned := r.Score < banThreshold()
+	if banned {
+		logger.Log("WARN", "peers", fmt.Sprintf("Peer %s fell to score %.0f; banning", nodeID, r.Score))
+		r.Score = initialScore()
+	}
+	rec := *r
+	rb.mu.Unlock()
+
+	if err := db.PutJSON(db.BucketReputation, nodeID, rec); err != nil {
+		logger.Log("ERROR", "peers", "Failed to save reputation: "+err.Error())
+	}
+	if banned {
+		Bans().BanNode(nodeID, "low reputation: "+banReason, banDuration())
+	}
+}
+
+// Forget drops nodeID's record, e.g. after the peer is removed for good.
+func (rb *ReputationBook) Forget(nodeID string) {
+	rb.mu.Lock()
+	delete(rb.scores, nodeID)
+	rb.mu.Unlock()
+	if err := db.Delete(db.BucketReputation, nodeID); err != nil {
+		logger.Log("ERROR", "peers", "Failed to delete reputation: "+err.Error())
+	}
+}
+
+// rankByReputation orders peers for dialing with a weighted shuffle: each peer's chance to come
+// first is proportional to its score, so good peers are preferred without starving the rest.
+func rankByReputation(peers []PeerEntry) []PeerEntry {
+	rb := Reputations()
+	keys := make(map[string]float64, len(peers))
+	for _, p := range peers {
+		w := rb.Score(p.NodeID) + 1
+		keys[p.NodeID] = math.Pow(rand.Float64(), 1/w)
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		return keys[peers[i].NodeID] > keys[peers[j].NodeID]
+	})
+	return peers
+}
+
+// isInvalidPayload reports whether err means the peer sent something malformed, as opposed to
+// the exchange simply failing (timeouts, resets), which is only a plain failure.
+func isInvalidPayload(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, errBadMagic) ||
+		errors.Is(err, errFrameTooLarge) || errors.Is(err, errUnexpectedFrame)
+}
+
+// badOwnRecord reports whether a peer list from sender carries sender's own record with a signature
+// that does not verify. Records about other nodes are only relayed, so a bad signature on one of
+// those is not the sender's fault: admitPeer drops the record and nobody is penalised.
+func badOwnRecord(peers []PeerEntry, sender string) bool {
+	for _, p := range peers {
+		if p.NodeID == sender && p.Signature != "" && verifyPeerEntry(p) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/types"
+)
+
+const (
+	// roleProofTTL is how long a role proof stays valid.
+	roleProofTTL = 24 * time.Hour
+	// roleProofRenew is how close to expiry our own proof is re-signed.
+	roleProofRenew = time.Hour
+)
+
+var errRoleUnproven = errors.New("role claim has no valid proof")
+
+// provenRoles maps each role that needs a proof to the trust roles whose keys may sign one. A bot
+// frontend is vouched for by an operator key, so a node cannot stand in bot elections by claim alone.
+var provenRoles = map[string][]string{
+	"admin": {types.RoleAdmin},
+	"bot":   {types.RoleAdmin, types.RoleOperator},
+}
+
+// roleSigningBytes is what a trusted key signs to vouch for nodeID holding role until expires.
+func roleSigningBytes(nodeID, role, expires string) []byte {
+	return []byte(strings.Join([]string{"atsuko-role", nodeID, role, expires}, "\n"))
+}
+
+// stampRole sets our own record's role and, for "admin" and "bot", attaches a proof signed with
+// identity.admin_key, reusing the current proof until it is close to expiry. Without a key whose
+// trust role may vouch for the role it falls back to "default". Call signSelfEntry afterwards.
+func stampRole(p *PeerEntry, role string) {
+	signers, proven := provenRoles[role]
+	if !proven {
+		p.Type, p.RoleExpires, p.RoleProof = role, "", ""
+		return
+	}
+	key, keyRole, ok := types.SigningKey()
+	if !ok || !slices.Contains(signers, keyRole) {
+		p.Type, p.RoleExpires, p.RoleProof = "default", "", ""
+		return
+	}
+	p.Type = role
+	if expires, err := parseTime(p.RoleExpires); err == nil && verifyRole(*p) == nil && time.Until(expires) > roleProofRenew {
+		return
+	}
+	p.RoleExpires = time.Now().UTC().Add(roleProofTTL).Format(time.RFC3339)
+	p.RoleProof = hex.EncodeToString(ed25519.Sign(key, roleSigningBytes(p.NodeID, role, p.RoleExpires)))
+}
+
+// verifyRole checks an "admin" or "bot" claim against the trusted keys that may vouch for it.
+// Other roles need no proof.
+func verifyRole(p PeerEntry) error {
+	signers, proven := provenRoles[p.Type]
+	if !proven {
+		return nil
+	}
+	expires, err := time.Parse(time.RFC3339, p.RoleExpires)
+	if err != nil || !time.Now().Before(expires) {
+		return errRoleUnproven
+	}
+	sig, err := hex.DecodeString(p.RoleProof)
+	if err != nil {
+		return errRoleUnproven
+	}
+	msg := roleSigningBytes(p.NodeID, p.Type, p.RoleExpires)
+	for _, pub := range types.RoleKeys(signers...) {
+		if ed25519.Verify(pub, msg, sig) {
+			return nil
+		}
+	}
+	return errRoleUnproven
+}
+
+// checkRole downgrades an unproven "admin" or "bot" claim to "default". The record's own signature covered
+// the false claim, so it is dropped too rather than relayed in a form that no longer verifies.
+func checkRole(p PeerEntry, source string) PeerEntry {
+	if err := verifyRole(p); err != nil {
+		logger.Log("WARN", "peers", fmt.Sprintf("Downgrading %s to default (from %s): %v", p.NodeID, source, err))
+		p.Type, p.RoleExpires, p.RoleProof, p.Signature = "default", "", "", ""
+	}
+	return p
+}
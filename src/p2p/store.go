@@ -0,0 +1,402 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+	"atsuko-nexus/src/nodeid"
+)
+
+// PeerStore keeps the peer set in memory behind a mutex and persists it to the peers bucket
+// of the embedded database. The listener, TapSync, discovery and the UI all share the single
+// store returned by Peers.
+type PeerStore struct {
+	mu    sync.RWMutex
+	peers map[string]PeerEntry
+
+	// contacted records when we last completed a handshake with each peer; it guides eviction.
+	contacted map[string]time.Time
+
+	// saveMu serialises writes so a slower, older snapshot never lands after a newer one.
+	saveMu sync.Mutex
+}
+
+// peerHistoryLimit is how many address changes are kept per peer.
+const peerHistoryLimit = 20
+
+// protectedShare limits the peers exempt from eviction to 1/protectedShare of network.max_peers.
+const protectedShare = 4
+
+// peersImportedKey marks in db metadata that the legacy YAML peer cache has been imported.
+const peersImportedKey = "peers_yaml_imported"
+
+var (
+	peerStore     *PeerStore
+	peerStoreOnce sync.Once
+)
+
+// Peers returns the process-wide peer store, loading it from the database on first use.
+func Peers() *PeerStore {
+	peerStoreOnce.Do(func() {
+		peerStore = OpenPeerStore()
+	})
+	return peerStore
+}
+
+// OpenPeerStore loads the peers bucket into a new store, importing the legacy peer cache file
+// (storage.peer_cache_file) the first time. If the database cannot be opened the store runs in memory only.
+func OpenPeerStore() *PeerStore {
+	s := &PeerStore{peers: make(map[string]PeerEntry), contacted: make(map[string]time.Time)}
+	if _, err := db.Open(); err != nil {
+		logger.Log("ERROR", "peers", "Peer store running in memory only: "+err.Error())
+		return s
+	}
+
+	importPeerFile(peerCachePath())
+
+	err := db.ForEach(db.BucketPeers, func(_ string, value []byte) error {
+		var p PeerEntry
+		if err := json.Unmarshal(value, &p); err != nil {
+			logger.Log("WARN", "peers", "Skipping unreadable peer record: "+err.Error())
+			return nil
+		}
+		s.peers[p.NodeID] = checkRole(p, "database")
+		return nil
+	})
+	if err != nil {
+		logger.Log("ERROR", "peers", "Failed to load peers: "+err.Error())
+	}
+	return s
+}
+
+// Get returns the entry for nodeID.
+func (s *PeerStore) Get(nodeID string) (PeerEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.peers[nodeID]
+	return p, ok
+}
+
+// Len returns the number of stored peers, including ourselves.
+func (s *PeerStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.peers)
+}
+
+// List returns a snapshot of every entry ordered by NodeID.
+func (s *PeerStore) List() []PeerEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+// Upsert inserts or replaces p unconditionally and persists the store.
+func (s *PeerStore) Upsert(p PeerEntry) {
+	s.mu.Lock()
+	s.peers[p.NodeID] = p
+	s.enforceLimit()
+	s.mu.Unlock()
+	s.persist()
+}
+
+// Add inserts p only when its NodeID is not yet known, and reports whether it did.
+func (s *PeerStore) Add(p PeerEntry) bool {
+	s.mu.Lock()
+	if _, ok := s.peers[p.NodeID]; ok {
+		s.mu.Unlock()
+		return false
+	}
+	s.peers[p.NodeID] = p
+	s.enforceLimit()
+	_, kept := s.peers[p.NodeID]
+	s.mu.Unlock()
+	s.persist()
+	return kept
+}
+
+// Update applies fn to the entry for nodeID under the lock and persists the result.
+// It reports whether the entry existed.
+func (s *PeerStore) Update(nodeID string, fn func(*PeerEntry)) bool {
+	s.mu.Lock()
+	p, ok := s.peers[nodeID]
+	if ok {
+		fn(&p)
+		s.peers[nodeID] = p
+	}
+	s.mu.Unlock()
+	if ok {
+		s.persist()
+	}
+	return ok
+}
+
+// Remove deletes nodeID and reports whether it was present.
+func (s *PeerStore) Remove(nodeID string) bool {
+	s.mu.Lock()
+	_, ok := s.peers[nodeID]
+	delete(s.peers, nodeID)
+	delete(s.contacted, nodeID)
+	s.mu.Unlock()
+	if ok {
+		s.persist()
+	}
+	return ok
+}
+
+// Merge folds incoming records into the store using mergePeers (newest LastSeen wins),
+// persists the result and returns the merged list.
+func (s *PeerStore) Merge(incoming []PeerEntry) []PeerEntry {
+	s.mu.Lock()
+	merged := mergePeers(s.snapshot(), incoming)
+	s.peers = make(map[string]PeerEntry, len(merged))
+	for _, p := range merged {
+		s.peers[p.NodeID] = p
+	}
+	s.enforceLimit()
+	out := s.snapshot()
+	s.mu.Unlock()
+	s.persist()
+	return out
+}
+
+// Purge drops every peer except ourselves and those keep returns true for, persists the
+// result and returns how many were removed.
+func (s *PeerStore) Purge(keep func(PeerEntry) bool) int {
+	selfID := nodeid.GetNodeID()
+	s.mu.Lock()
+	removed := 0
+	for id, p := range s.peers {
+		if id == selfID || (keep != nil && keep(p)) {
+			continue
+		}
+		delete(s.peers, id)
+		delete(s.contacted, id)
+		removed++
+	}
+	s.mu.Unlock()
+	s.persist()
+	return removed
+}
+
+// MarkContacted records a successful handshake with nodeID, which protects it from eviction.
+func (s *PeerStore) MarkContacted(nodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.peers[nodeID]; ok {
+		s.contacted[nodeID] = time.Now()
+	}
+}
+
+// enforceLimit trims the table to network.max_peers. Ourselves and, up to a quarter of the limit,
+// peers holding a verified admin role are never evicted. The rest are ranked by what we observed
+// ourselves, never by the LastSeen a record's sender chose: peers we handshook with come first,
+// most recent first, then the others by reputation, and the tail is dropped. Callers must hold mu.
+func (s *PeerStore) enforceLimit() {
+	limit := maxPeers()
+	selfID := nodeid.GetNodeID()
+
+	var candidates []PeerEntry
+	protected := 0
+	for id, p := range s.peers {
+		if id == selfID {
+			continue
+		}
+		if protected < limit/protectedShare && p.Type == "admin" && verifyRole(p) == nil {
+			protected++
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	excess := protected + len(candidates) - limit
+	if excess <= 0 {
+		return
+	}
+	if excess > len(candidates) {
+		excess = len(candidates)
+	}
+
+	rb := Reputations()
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := s.contacted[candidates[i].NodeID], s.contacted[candidates[j].NodeID]
+		if !ci.Equal(cj) {
+			return ci.After(cj)
+		}
+		si, sj := rb.Score(candidates[i].NodeID), rb.Score(candidates[j].NodeID)
+		if si != sj {
+			return si > sj
+		}
+		return candidates[i].NodeID < candidates[j].NodeID
+	})
+	for _, p := range candidates[len(candidates)-excess:] {
+		delete(s.peers, p.NodeID)
+		delete(s.contacted, p.NodeID)
+		logger.Log("DEBUG", "peers", fmt.Sprintf("Evicted %s (peer table over max_peers=%d)", p.NodeID, limit))
+	}
+	logger.Log("INFO", "peers", fmt.Sprintf("Peer table over limit; evicted %d peers", excess))
+}
+
+// Save writes the current peer set to the database in one transaction, recording address
+// changes in the peer history bucket.
+func (s *PeerStore) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	peers := s.snapshot()
+	s.mu.RUnlock()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(db.BucketPeers))
+		history := tx.Bucket([]byte(db.BucketPeerHistory))
+
+		keep := make(map[string]bool, len(peers))
+		for _, p := range peers {
+			keep[p.NodeID] = true
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if prev := bucket.Get([]byte(p.NodeID)); prev != nil {
+				var old PeerEntry
+				if json.Unmarshal(prev, &old) == nil && addressChanged(old, p) {
+					if err := recordPeerHistory(history, old); err != nil {
+						return err
+					}
+				}
+			}
+			if err := bucket.Put([]byte(p.NodeID), data); err != nil {
+				return err
+			}
+		}
+
+		var stale [][]byte
+		_ = bucket.ForEach(func(k, _ []byte) error {
+			if !keep[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addressChanged reports whether a peer moved to a different address.
+func addressChanged(old, cur PeerEntry) bool {
+	return old.IPv4 != cur.IPv4 || old.IPv6 != cur.IPv6 || old.Port != cur.Port
+}
+
+// recordPeerHistory appends old to the peer's history and trims it to peerHistoryLimit.
+func recordPeerHistory(history *bolt.Bucket, old PeerEntry) error {
+	b, err := history.CreateBucketIfNotExists([]byte(old.NodeID))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	// Fixed-width key: big-endian UnixNano, then the sequence to break ties, so keys sort chronologically
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	if err := b.Put(key, data); err != nil {
+		return err
+	}
+
+	// Keys sort oldest first; collect before deleting so the cursor does not skip entries.
+	var keys [][]byte
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys[:max(len(keys)-peerHistoryLimit, 0)] {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persist saves the store and logs, rather than returns, failures; mutations stay applied in memory.
+func (s *PeerStore) persist() {
+	if err := s.Save(); err != nil {
+		logger.Log("ERROR", "peers", "Failed to save peer cache: "+err.Error())
+	}
+}
+
+// snapshot copies the peer map into a sorted slice. Callers must hold mu.
+func (s *PeerStore) snapshot() []PeerEntry {
+	out := make([]PeerEntry, 0, len(s.peers))
+	for _, p := range s.peers {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
+
+// readPeerFile loads the peer list from the legacy YAML peer cache.
+func readPeerFile(path string) []PeerEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Log("ERROR", "peers", fmt.Sprintf("Failed to read peer file at %s: %v", path, err))
+		}
+		return nil
+	}
+	var pf PeerFile
+	if err := yaml.Unmarshal(data, &pf); err != nil {
+		logger.Log("ERROR", "peers", "Failed to unmarshal peer file: "+err.Error())
+		return nil
+	}
+	return pf.Peers
+}
+
+// importPeerFile copies the legacy YAML peer cache into the database once.
+func importPeerFile(path string) {
+	if db.GetMeta(peersImportedKey) != "" {
+		return
+	}
+	peers := readPeerFile(path)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(db.BucketPeers))
+		for _, p := range peers {
+			if p.NodeID == "" || bucket.Get([]byte(p.NodeID)) != nil {
+				continue
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(p.NodeID), data); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte(db.BucketMeta)).Put([]byte(peersImportedKey), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		logger.Log("ERROR", "peers", "Failed to import peer cache file: "+err.Error())
+		return
+	}
+	if len(peers) > 0 {
+		logger.Log("INFO", "peers", fmt.Sprintf("Imported %d peers from %s", len(peers), path))
+	}
+}
@@ -1,40 +1,24 @@
 package p2p
 
 import (
-    "bufio"
-    "encoding/json"
+    "context"
+    "errors"
     "fmt"
-    "math/rand"
     "net"
-    "os"
-    "path/filepath"
     "time"
 
+    "atsuko-nexus/src/hlc"
     "atsuko-nexus/src/logger"
     "atsuko-nexus/src/nodeid"
-    "atsuko-nexus/src/settings"
 )
 
-var (
-    staletime = 24 * time.Hour
-)
-
-func TapSync() {
+// TapSync syncs peer lists with the first reachable candidate. It stops early once ctx is cancelled.
+func TapSync(ctx context.Context) {
     logger.Log("DEBUG", "tapsync", "Running TapSync")
 
-    // Resolve peerCache path
-    exe, err := os.Executable()
-    if err != nil {
-        logger.Log("ERROR", "tapsync", "os.Executable failed: "+err.Error())
-        return
-    }
-    baseDir := filepath.Dir(exe)
-    peerRel := fmt.Sprint(settings.Get("storage.peer_cache_file"))
-    peerPath := filepath.Join(baseDir, peerRel)
-    logger.Log("DEBUG", "tapsync", "Peer cache file (absolute): "+peerPath)
-
     // 1) Load peers
-    peers := loadPeers(peerPath)
+    store := Peers()
+    peers := store.List()
     logger.Log("DEBUG", "tapsync", fmt.Sprintf("Loaded %d peers", len(peers)))
 
     // 2) Filter out self & invalid IPs
@@ -44,6 +28,9 @@ func TapSync() {
         if p.NodeID == selfID {
             continue
         }
+        if Bans().IsNodeBanned(p.NodeID) || !Health().Ready(p.NodeID) {
+            continue
+        }
         if net.ParseIP(p.IPv4) == nil {
             logger.Log("WARN", "tapsync", fmt.Sprintf("Skipping peer %s (invalid IPv4 %s)", p.NodeID, p.IPv4))
             continue
@@ -52,85 +39,133 @@ func TapSync() {
     }
     logger.Log("DEBUG", "tapsync", fmt.Sprintf("Found %d candidate peers", len(candidates)))
     if len(candidates) == 0 {
-        logger.Log("WARN", "tapsync", "No other peers to sync with.")
+        logger.Log("WARN", "tapsync", "No other peers ready to sync with.")
         return
     }
 
-    // 3) Shuffle and try each
-    rand.Shuffle(len(candidates), func(i, j int) {
-        candidates[i], candidates[j] = candidates[j], candidates[i]
-    })
+    // 3) Order by reputation (weighted shuffle) and try each
+    candidates = rankByReputation(candidates)
 
+    rep := Reputations()
     for _, peer := range candidates {
+        if ctx.Err() != nil {
+            return
+        }
         addr := net.JoinHostPort(peer.IPv4, fmt.Sprint(peer.Port))
         logger.Log("DEBUG", "tapsync", "Dialing "+peer.NodeID)
-        conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+        started := time.Now()
+        pc, err := dialNexus(addr, peer.NodeID)
         if err != nil {
-            lastSeen := parseTime(peer.LastSeen)
-            if time.Since(lastSeen) > staletime {
-                logger.Log("INFO", "tapsync", fmt.Sprintf("Peer %s stale; removing.", peer.NodeID))
-                peers = removePeer(peers, peer.NodeID)
-                savePeers(peerPath, peers)
+            if errors.Is(err, errIdentityMismatch) {
+                rep.RecordInvalid(peer.NodeID, err.Error())
+            } else {
+                rep.RecordFailure(peer.NodeID)
+            }
+            state, next := Health().Failed(peer.NodeID)
+            if state == StateDead {
+                logger.Log("INFO", "tapsync", fmt.Sprintf("Peer %s dead after %d reconnect attempts; removing.", peer.NodeID, reconnectAttempts()))
+                store.Remove(peer.NodeID)
+                rep.Forget(peer.NodeID)
+                Skews().Forget(peer.NodeID)
+                dhtTable().Remove(peer.NodeID)
             } else {
-                logger.Log("WARN", "tapsync", fmt.Sprintf("Peer %s unreachable; skipping.", peer.NodeID))
+                logger.Log("WARN", "tapsync", fmt.Sprintf("Peer %s unreachable (%s); next attempt in %s.",
+                    peer.NodeID, state, time.Until(next).Round(time.Second)))
             }
             continue
         }
-        defer conn.Close()
+        defer pc.Close()
+        store.MarkContacted(peer.NodeID)
+        Health().Succeeded(peer.NodeID)
+
+        // 4) v3+ peers reconcile by digest and only exchange the buckets that differ
+        if pc.version >= versionAntiEntropy {
+            bumpSelfLastSeen()
+            if err := syncAntiEntropy(pc); err != nil {
+                logger.Log("ERROR", "tapsync", "Digest sync failed: "+err.Error())
+                recordExchangeError(peer.NodeID, err)
+                continue
+            }
+            // 5) v6+ peers then reconcile the replicated key-value store on the same session
+            if pc.version >= versionKV {
+                if err := syncKV(pc); err != nil {
+                    logger.Log("ERROR", "kv", "KV sync failed: "+err.Error())
+                    recordExchangeError(peer.NodeID, err)
+                    continue
+                }
+            }
+            // 6) v7+ peers finally exchange trust logs, so missed trust changes are caught up
+            if pc.version >= versionTrust {
+                if err := syncTrust(pc); err != nil {
+                    logger.Log("ERROR", "admin", "Trust sync failed: "+err.Error())
+                    recordExchangeError(peer.NodeID, err)
+                    continue
+                }
+            }
+            rep.RecordSuccess(peer.NodeID, time.Since(started))
+            return
+        }
+
+        // Older peers get the full-list exchange
 
         // 4a) Send SYNC
-        if _, err := conn.Write([]byte("SYNC\n")); err != nil {
+        if err := pc.send(MsgSync, nil); err != nil {
             logger.Log("ERROR", "tapsync", "Failed to send SYNC: "+err.Error())
             continue
         }
 
-        rdr := bufio.NewReader(conn)
-        conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-
         // 4b) Read their peer list
-        incoming, err := rdr.ReadString('\n')
-        if err != nil {
+        var theirPeers []PeerEntry
+        if err := pc.recvMax(MsgPeerList, &theirPeers, maxPeerListBytes()); err != nil {
             logger.Log("ERROR", "tapsync", "Failed to read peers: "+err.Error())
+            recordExchangeError(peer.NodeID, err)
             continue
         }
-        var theirPeers []PeerEntry
-        if err := json.Unmarshal([]byte(incoming), &theirPeers); err != nil {
-            logger.Log("ERROR", "tapsync", "JSON unmarshal error: "+err.Error())
-            continue
+        if badOwnRecord(theirPeers, peer.NodeID) {
+            rep.RecordInvalid(peer.NodeID, "own record has a bad signature")
         }
+        theirPeers = capPeerList(theirPeers, peer.NodeID)
         logger.Log("INFO", "tapsync", fmt.Sprintf("Received %d peers", len(theirPeers)))
 
         // 4c) Bump our LastSeen and save
-        for i := range peers {
-            if peers[i].NodeID == selfID {
-                peers[i].LastSeen = time.Now().UTC().Format(time.RFC3339)
-                break
-            }
-        }
-        savePeers(peerPath, peers)
+        bumpSelfLastSeen()
 
         // 4d) Send our updated list
-        out, _ := json.Marshal(peers)
-        conn.Write(out)
-        conn.Write([]byte("\n"))
-
-        // 4e) Read merged response
-        conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-        mergedResp, err := rdr.ReadString('\n')
-        if err == nil {
+        if err := pc.send(MsgPeerList, store.List()); err == nil {
+            // 4e) Read merged response
             var merged []PeerEntry
-            if err := json.Unmarshal([]byte(mergedResp), &merged); err == nil {
+            err := pc.recvMax(MsgSyncMerged, &merged, maxPeerListBytes())
+            if err == nil {
                 logger.Log("INFO", "tapsync", fmt.Sprintf("Got merged list (%d entries)", len(merged)))
-                savePeers(peerPath, merged)
+                rep.RecordSuccess(peer.NodeID, time.Since(started))
+                store.Merge(capPeerList(merged, peer.NodeID))
                 return
             }
+            recordExchangeError(peer.NodeID, err)
         }
 
         // 5) Fallback: manual merge
-        final := mergePeers(peers, theirPeers)
-        savePeers(peerPath, final)
+        store.Merge(theirPeers)
         return
     }
 
     logger.Log("WARN", "tapsync", "Could not connect to any peer.")
 }
+
+// bumpSelfLastSeen refreshes and re-signs our own entry before it is sent to a peer.
+func bumpSelfLastSeen() {
+    Peers().Update(nodeid.GetNodeID(), func(p *PeerEntry) {
+        p.LastSeen = hlc.Default().Now().RFC3339()
+        signSelfEntry(p)
+    })
+}
+
+// recordExchangeError charges a failed exchange to the peer's reputation: malformed payloads
+// count as invalid, anything else (timeouts, resets) is only counted as a failure.
+func recordExchangeError(nodeID string, err error) {
+    if isInvalidPayload(err) {
+        Reputations().RecordInvalid(nodeID, err.Error())
+        return
+    }
+    Reputations().RecordFailure(nodeID)
+}
@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/tasks"
+)
+
+// Work stealing (protocol v5) lets an idle node take queued tasks from a busy one:
+//
+//	worker                                owner
+//	TASK-STEAL {capacity, types}     ->
+//	                                 <-   TASK-LEASES [lease...]
+//	... runs the tasks ...
+//	TASK-REPORT {lease, result}      ->
+//	                                 <-   TASK-REPORT-ACK {ok, error}
+//
+// The owner keeps each leased task in its persistent queue and queues it again if no report
+// arrives before the lease expires, so a worker that disappears loses no work.
+const (
+	// versionTasks is the first protocol version that carries TASK-* frames.
+	versionTasks uint8 = 5
+
+	// leaseFreshness is how recently a peer's LastSeen must have been refreshed for it to
+	// lend or borrow work.
+	leaseFreshness = 10 * time.Minute
+)
+
+// taskStealPayload advertises a worker's spare capacity and the job types it can run.
+type taskStealPayload struct {
+	Capacity int      `json:"capacity"`
+	Types    []string `json:"types"`
+}
+
+// taskReportAckPayload tells the worker whether its report was accepted.
+type taskReportAckPayload struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// taskRemote carries work stealing over short-lived Nexus sessions.
+type taskRemote struct{}
+
+// TaskRemote returns the tasks.Remote that steals work from, and reports results to, known peers.
+func TaskRemote() tasks.Remote {
+	return taskRemote{}
+}
+
+// Peers returns dialable, unbanned peers whose LastSeen is fresh, most recently seen first.
+func (taskRemote) Peers() []string {
+	selfID := nodeid.GetNodeID()
+	var fresh []PeerEntry
+	for _, p := range Peers().List() {
+		if p.NodeID == selfID || net.ParseIP(p.IPv4) == nil || !isFresh(p) {
+			continue
+		}
+		if Bans().IsNodeBanned(p.NodeID) || !Health().Ready(p.NodeID) {
+			continue
+		}
+		fresh = append(fresh, p)
+	}
+	sort.Slice(fresh, func(i, j int) bool {
+		return seenAfter(fresh[i], fresh[j])
+	})
+	out := make([]string, len(fresh))
+	for i, p := range fresh {
+		out[i] = p.NodeID
+	}
+	return out
+}
+
+// Steal asks nodeID to lease us up to capacity tasks of the given types.
+func (taskRemote) Steal(nodeID string, capacity int, types []string) ([]tasks.Lease, error) {
+	pc, err := dialKnownPeer(nodeID, versionTasks, "work stealing")
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	if err := pc.send(MsgTaskSteal, taskStealPayload{Capacity: capacity, Types: types}); err != nil {
+		return nil, err
+	}
+	var leases []tasks.Lease
+	if err := pc.recv(MsgTaskLeases, &leases); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+// Report hands the outcome of a leased task back to its owner.
+func (taskRemote) Report(nodeID string, r tasks.Report) error {
+	pc, err := dialKnownPeer(nodeID, versionTasks, "work stealing")
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	if err := pc.send(MsgTaskReport, r); err != nil {
+		return err
+	}
+	var ack taskReportAckPayload
+	if err := pc.recv(MsgTaskReportAck, &ack); err != nil {
+		return err
+	}
+	if !ack.OK {
+		if ack.Error == tasks.ErrStaleLease.Error() {
+			return tasks.ErrStaleLease
+		}
+		return errors.New(ack.Error)
+	}
+	return nil
+}
+
+// serveTaskSteal leases surplus tasks to a worker whose peer record is fresh; anyone else gets
+// an empty reply.
+func serveTaskSteal(pc *peerConn, req taskStealPayload) error {
+	var leases []tasks.Lease
+	if peer, ok := Peers().Get(pc.remoteID); ok && isFresh(peer) {
+		leases = tasks.Default().HandleSteal(pc.remoteID, req.Capacity, req.Types)
+	}
+	if leases == nil {
+		leases = []tasks.Lease{}
+	}
+	return pc.send(MsgTaskLeases, leases)
+}
+
+// serveTaskReport applies a worker's report on a task it leased from us.
+func serveTaskReport(pc *peerConn, r tasks.Report) error {
+	ack := taskReportAckPayload{OK: true}
+	if err := tasks.Default().HandleReport(pc.remoteID, r); err != nil {
+		ack = taskReportAckPayload{Error: err.Error()}
+	}
+	return pc.send(MsgTaskReportAck, ack)
+}
+
+// isFresh reports whether p refreshed its LastSeen within leaseFreshness.
+func isFresh(p PeerEntry) bool {
+	seen, ok := lastSeen(p)
+	return ok && time.Since(seen) < leaseFreshness
+}
+
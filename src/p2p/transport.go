@@ -0,0 +1,165 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/nodeid"
+	"atsuko-nexus/src/types"
+)
+
+// Peer links run over TLS 1.3. Each node presents a self-signed certificate for its Ed25519
+// identity key, and both sides pin the remote certificate key to the NodeID it claims instead
+// of trusting any CA, so the channel works fully offline between local nodes.
+
+// tlsRecordHandshake is the first byte of every TLS ClientHello.
+const tlsRecordHandshake = 0x16
+
+var (
+	errNoIdentity       = errors.New("no local identity key")
+	errIdentityMismatch = errors.New("remote identity does not match its NodeID")
+
+	certOnce sync.Once
+	certTLS  tls.Certificate
+	certErr  error
+)
+
+// localCertificate returns a self-signed certificate for the local identity key.
+func localCertificate() (tls.Certificate, error) {
+	certOnce.Do(func() {
+		id := types.LocalIdentity()
+		if id == nil {
+			certErr = errNoIdentity
+			return
+		}
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+		if err != nil {
+			certErr = err
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: nodeid.GetNodeID()},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().AddDate(10, 0, 0),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, id.Public, id.Private)
+		if err != nil {
+			certErr = err
+			return
+		}
+		certTLS = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: id.Private}
+	})
+	return certTLS, certErr
+}
+
+// certIdentityKey extracts the Ed25519 identity key from the remote's leaf certificate.
+func certIdentityKey(rawCerts [][]byte) (ed25519.PublicKey, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("remote presented no certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("remote certificate is not an Ed25519 identity key")
+	}
+	return pub, nil
+}
+
+// identityMatches reports whether pub is the identity key for nodeID. Key-derived IDs are
+// checked directly; hardware-mode IDs must match the key pinned in the peer cache, and are
+// accepted on first contact when no key is pinned yet.
+func identityMatches(nodeID string, pub ed25519.PublicKey) bool {
+	if nodeID == "" {
+		return false
+	}
+	if nodeid.FromPublicKey(pub) == nodeID {
+		return true
+	}
+	if p, ok := Peers().Get(nodeID); ok && p.PublicKey != "" {
+		return p.PublicKey == hex.EncodeToString(pub)
+	}
+	return true
+}
+
+// tlsServerConfig requires a client certificate; the identity check happens once HELLO names the NodeID.
+func tlsServerConfig() (*tls.Config, error) {
+	cert, err := localCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MinVersion:   tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, err := certIdentityKey(rawCerts)
+			return err
+		},
+	}, nil
+}
+
+// tlsClientConfig pins the server certificate to expectedID when the caller knows which node it is dialing.
+func tlsClientConfig(expectedID string) (*tls.Config, error) {
+	cert, err := localCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true, // no CA: the certificate is verified against the NodeID below
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			pub, err := certIdentityKey(rawCerts)
+			if err != nil {
+				return err
+			}
+			if expectedID != "" && !identityMatches(expectedID, pub) {
+				return fmt.Errorf("%w: expected %s", errIdentityMismatch, expectedID)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// peerKey returns the identity key the remote proved during the TLS handshake.
+func peerKey(tc *tls.Conn) ed25519.PublicKey {
+	state := tc.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	pub, _ := state.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+	return pub
+}
+
+// isTLSHandshake reports whether the buffered connection starts with a TLS record.
+func isTLSHandshake(rdr *bufio.Reader) bool {
+	head, err := rdr.Peek(1)
+	return err == nil && head[0] == tlsRecordHandshake
+}
+
+// bufferedConn replays bytes already peeked into a bufio.Reader before reading from the socket.
+type bufferedConn struct {
+	net.Conn
+	rdr *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.rdr.Read(p)
+}
@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"atsuko-nexus/src/logger"
+)
+
+// Trust log sync (protocol v7) runs on the TapSync session after the peer list and KV store, so
+// a node that was offline while the trust set changed catches up on its next sync:
+//
+//	initiator                          responder
+//	TRUST-SYNC {hash}           ->
+//	                            <-     TRUST-LOG {hash, every entry unless the hashes match}
+//	TRUST-LOG {every entry}     ->     (only if the hashes differed)
+//
+// The entries are signed trust commands; p2p treats them as opaque and leaves checking them to the
+// TrustLog that the admin package installs.
+const versionTrust uint8 = 7
+
+// MaxTrustEntries bounds the trust log a peer may send in one message, and so the log a node keeps.
+const MaxTrustEntries = 1024
+
+// TrustLog is the replicated record of trust changes.
+type TrustLog interface {
+	// Hash identifies the set of entries held, so two logs can be compared cheaply.
+	Hash() string
+	// Entries returns every entry held.
+	Entries() []json.RawMessage
+	// Merge verifies and adds entries from a peer and returns how many were new.
+	Merge(entries []json.RawMessage) int
+}
+
+// trustSyncPayload is both the TRUST-SYNC offer and the TRUST-LOG reply.
+type trustSyncPayload struct {
+	Hash    string            `json:"hash"`
+	Entries []json.RawMessage `json:"entries,omitempty"`
+}
+
+var (
+	trustLog   TrustLog
+	trustLogMu sync.RWMutex
+)
+
+// SetTrustLog installs the trust log exchanged with peers.
+func SetTrustLog(l TrustLog) {
+	trustLogMu.Lock()
+	trustLog = l
+	trustLogMu.Unlock()
+}
+
+func currentTrustLog() TrustLog {
+	trustLogMu.RLock()
+	defer trustLogMu.RUnlock()
+	return trustLog
+}
+
+// syncTrust runs the initiator side of a trust log sync on an established v7+ session.
+func syncTrust(pc *peerConn) error {
+	log := currentTrustLog()
+	if log == nil {
+		return nil
+	}
+	ours := log.Hash()
+	if err := pc.send(MsgTrustSync, trustSyncPayload{Hash: ours}); err != nil {
+		return err
+	}
+	var theirs trustSyncPayload
+	if err := pc.recv(MsgTrustLog, &theirs); err != nil {
+		return err
+	}
+	if theirs.Hash == ours {
+		return nil
+	}
+	if len(theirs.Entries) > MaxTrustEntries {
+		return fmt.Errorf("%w: TRUST-LOG has %d entries", errUnexpectedFrame, len(theirs.Entries))
+	}
+	sent := log.Entries()
+	added := log.Merge(theirs.Entries)
+	if err := pc.send(MsgTrustLog, trustSyncPayload{Hash: log.Hash(), Entries: sent}); err != nil {
+		return err
+	}
+	logger.Log("INFO", "admin", fmt.Sprintf("Exchanged trust logs with %s: %d new entries, sent %d", pc.remoteID, added, len(sent)))
+	return nil
+}
+
+// serveTrust runs the responder side of a trust log sync.
+func serveTrust(pc *peerConn, req trustSyncPayload) error {
+	log := currentTrustLog()
+	if log == nil {
+		pc.sendError("trust sync unavailable")
+		return nil
+	}
+	ours := log.Hash()
+	if req.Hash == ours {
+		return pc.send(MsgTrustLog, trustSyncPayload{Hash: ours})
+	}
+	if err := pc.send(MsgTrustLog, trustSyncPayload{Hash: ours, Entries: log.Entries()}); err != nil {
+		return err
+	}
+	var back trustSyncPayload
+	if err := pc.recv(MsgTrustLog, &back); err != nil {
+		return err
+	}
+	if len(back.Entries) > MaxTrustEntries {
+		return fmt.Errorf("%w: TRUST-LOG has %d entries", errUnexpectedFrame, len(back.Entries))
+	}
+	if added := log.Merge(back.Entries); added > 0 {
+		logger.Log("INFO", "admin", fmt.Sprintf("Received %d new trust log entries from %s", added, pc.remoteID))
+	}
+	return nil
+}
+
+// FetchTrustLog reads the trust log held by the node at addr without sending it anything, so a
+// command line tool can see which trust commands are in force before signing a new one.
+func FetchTrustLog(addr string) ([]json.RawMessage, error) {
+	pc, err := dialNexus(addr, "")
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	if pc.version < versionTrust {
+		return nil, fmt.Errorf("%s speaks protocol v%d, trust sync needs v%d", addr, pc.version, versionTrust)
+	}
+	if err := pc.send(MsgTrustSync, trustSyncPayload{}); err != nil {
+		return nil, err
+	}
+	var theirs trustSyncPayload
+	if err := pc.recv(MsgTrustLog, &theirs); err != nil {
+		return nil, err
+	}
+	// No hash matches the empty offer, so the responder waits for a log back: send it nothing.
+	if err := pc.send(MsgTrustLog, trustSyncPayload{}); err != nil {
+		return nil, err
+	}
+	return theirs.Entries, nil
+}
@@ -1,8 +1,6 @@
 package p2p
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -10,11 +8,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/huin/goupnp/dcps/internetgateway1"
+	"atsuko-nexus/src/hlc"
 	"atsuko-nexus/src/logger"
-	"gopkg.in/yaml.v3"
+	"atsuko-nexus/src/settings"
 )
 
 // PeerFile holds the list of peers
@@ -30,6 +30,17 @@ type PeerEntry struct {
 	IPv6     string `yaml:"ipv6" json:"ipv6"`
 	Port     int    `yaml:"port" json:"port"`
 	LastSeen string `yaml:"last_seen" json:"last_seen"`
+
+	// LegacyID is the hardware NodeID a key-mode node used before migrating, so peers can map old to new.
+	LegacyID string `yaml:"legacy_id,omitempty" json:"legacy_id,omitempty"`
+
+	// PublicKey is the node's hex Ed25519 identity key; Signature covers every field above.
+	PublicKey string `yaml:"public_key,omitempty" json:"public_key,omitempty"`
+	Signature string `yaml:"signature,omitempty" json:"signature,omitempty"`
+
+	// RoleProof is a trusted key's signature vouching for an "admin" or "bot" Type until RoleExpires.
+	RoleExpires string `yaml:"role_expires,omitempty" json:"role_expires,omitempty"`
+	RoleProof   string `yaml:"role_proof,omitempty" json:"role_proof,omitempty"`
 }
 
 // Fetch external IP from an API
@@ -54,91 +65,31 @@ func fetchPublicIP(apiURL string) string {
 
 // Request peer list from another node via TCP
 func fetchPeerListTCP(addr string) []PeerEntry {
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	pc, err := dialNexus(addr, "")
 	if err != nil {
 		logger.Log("ERROR", "nexus", "Failed to connect to "+addr+": "+err.Error())
 		return nil
 	}
-	defer conn.Close()
+	defer pc.Close()
 
-	_, err = conn.Write([]byte("PEERLIST\n"))
-	if err != nil {
+	if err := pc.send(MsgPeerListRequest, nil); err != nil {
 		logger.Log("ERROR", "nexus", "Failed to send request: "+err.Error())
 		return nil
 	}
 
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	resp, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		logger.Log("ERROR", "nexus", "Failed to read response: "+err.Error())
-		return nil
-	}
-
 	var peers []PeerEntry
-	if err := json.Unmarshal([]byte(resp), &peers); err != nil {
-		logger.Log("ERROR", "nexus", "Invalid peer format: "+err.Error())
+	if err := pc.recvMax(MsgPeerList, &peers, maxPeerListBytes()); err != nil {
+		logger.Log("ERROR", "nexus", "Failed to read peer list: "+err.Error())
 		return nil
 	}
 	logger.Log("INFO", "nexus", "Peer list received from "+addr)
 	return peers
 }
 
-// Load peer list from YAML file
-func loadPeers(path string) []PeerEntry {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		logger.Log("ERROR", "peers", fmt.Sprintf("Failed to read peer file at %s: %v", path, err))
-		return []PeerEntry{}
-	}
-	var pf PeerFile
-	if err := yaml.Unmarshal(data, &pf); err != nil {
-		logger.Log("ERROR", "peers", "Failed to unmarshal peer file: "+err.Error())
-		return []PeerEntry{}
-	}
-	return pf.Peers
-}
-
-
-// Save peer list to YAML file
-func savePeers(path string, peers []PeerEntry) {
-	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-		logger.Log("WARN", "nexus", fmt.Sprintf("A directory named '%s' exists — removing to save file properly.", path))
-		if err := os.RemoveAll(path); err != nil {
-			logger.Log("ERROR", "nexus", fmt.Sprintf("Failed to remove directory '%s': %v", path, err))
-			return
-		}
-	}
-
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		logger.Log("ERROR", "nexus", fmt.Sprintf("Failed to create directory '%s': %v", dir, err))
-		return
-	}
-
-	data, err := yaml.Marshal(PeerFile{Peers: peers})
-	if err != nil {
-		logger.Log("ERROR", "nexus", "Failed to encode peers: "+err.Error())
-		return
-	}
-
-	err = os.WriteFile(path, data, 0644)
-	if err != nil {
-		logger.Log("ERROR", "nexus", fmt.Sprintf("Failed to save peer file to '%s': %v", path, err))
-		return
-	}
-
-	logger.Log("DEBUG", "nexus", fmt.Sprintf("Successfully saved peers.yaml to '%s'", path))
-}
-
-// Insert or update a peer entry
-func upsertPeer(list []PeerEntry, new PeerEntry) []PeerEntry {
-	for i, p := range list {
-		if p.NodeID == new.NodeID {
-			list[i] = new
-			return list
-		}
-	}
-	return append(list, new)
+// peerCachePath resolves storage.peer_cache_file relative to the executable.
+func peerCachePath() string {
+	exePath, _ := os.Executable()
+	return filepath.Join(filepath.Dir(exePath), fmt.Sprint(settings.Get("storage.peer_cache_file")))
 }
 
 // Simple format validation for peer addresses
@@ -163,8 +114,12 @@ func isPortListening(port int) bool {
 // Try forwarding port via UPnP
 func tryUPnPForward(listenPort int) {
     devices, _, err := internetgateway1.NewWANIPConnection1Clients()
-    if err != nil || len(devices) == 0 {
-        logger.Log("ERROR", "upnp", "No UPnP IGD found or error: "+err.Error())
+    if err != nil {
+        logger.Log("ERROR", "upnp", "UPnP discovery failed: "+err.Error())
+        return
+    }
+    if len(devices) == 0 {
+        logger.Log("WARN", "upnp", "No UPnP IGD found")
         return
     }
     client := devices[0]
@@ -202,6 +157,61 @@ func tryUPnPForward(listenPort int) {
     logger.Log("INFO", "upnp",
         fmt.Sprintf("Successfully requested mapping WAN:%d→LAN:%d (lease %d)",
           listenPort, listenPort, lease))
+
+    upnpMu.Lock()
+    upnpClient = client
+    upnpPort = listenPort
+    upnpMu.Unlock()
+}
+
+// upnpClient and upnpPort remember the mapping made by tryUPnPForward so it can be removed on shutdown.
+var (
+    upnpMu     sync.Mutex
+    upnpClient *internetgateway1.WANIPConnection1
+    upnpPort   int
+)
+
+// ReleaseUPnP removes the port mapping created at bootstrap, if any.
+func ReleaseUPnP() error {
+    upnpMu.Lock()
+    client, port := upnpClient, upnpPort
+    upnpClient = nil
+    upnpMu.Unlock()
+    if client == nil {
+        return nil
+    }
+    if err := client.DeletePortMapping("", uint16(port), "TCP"); err != nil {
+        logger.Log("ERROR", "upnp", fmt.Sprintf("DeletePortMapping(%d) failed: %v", port, err))
+        return err
+    }
+    logger.Log("INFO", "upnp", fmt.Sprintf("Removed mapping WAN:%d", port))
+    return nil
+}
+
+// FlushPeers folds the DHT routing table into the peer store and writes it to disk.
+func FlushPeers() error {
+    saveRoutingTable(dhtTable())
+    if err := Peers().Save(); err != nil {
+        logger.Log("ERROR", "peers", "Failed to flush peer cache: "+err.Error())
+        return err
+    }
+    logger.Log("DEBUG", "peers", "Peer cache flushed")
+    return nil
+}
+
+// PurgePeers empties the peer cache and routing table, keeping ourselves and peers with a verified admin role,
+// so the node re-learns the network from fresh syncs. It returns how many peers were dropped.
+func PurgePeers() int {
+    store := Peers()
+    removed := store.Purge(func(p PeerEntry) bool { return p.Type == "admin" && verifyRole(p) == nil })
+    rt := dhtTable()
+    for _, p := range rt.Entries() {
+        if _, ok := store.Get(p.NodeID); !ok {
+            rt.Remove(p.NodeID)
+        }
+    }
+    logger.Log("INFO", "peers", fmt.Sprintf("Peer cache purged; dropped %d peers", removed))
+    return removed
 }
 
 // Discover local network IP address
@@ -215,7 +225,10 @@ func getLocalIP() (net.IP, error) {
 	return localAddr.IP, nil
 }
 
-// mergePeers merges existing and incoming lists by taking the record with the newest LastSeen timestamp for each NodeID.
+// mergePeers merges existing and incoming lists by taking the record with the newest LastSeen for each NodeID.
+// LastSeen is a hybrid logical clock stamp: every incoming stamp is folded into our clock, and
+// records stamped further in the future than clock.max_skew are refused, so a node whose clock
+// runs ahead cannot win every merge.
 func mergePeers(existing []PeerEntry, incoming []PeerEntry) []PeerEntry {
     // Start with a map for easy lookup
     m := make(map[string]PeerEntry, len(existing))
@@ -225,11 +238,40 @@ func mergePeers(existing []PeerEntry, incoming []PeerEntry) []PeerEntry {
 
     // For each incoming
     for _, inc := range incoming {
-        if ex, ok := m[inc.NodeID]; ok {
-            // Compare timestamps
-            tEx  := parseTime(ex.LastSeen)
-            tInc := parseTime(inc.LastSeen)
-            if tInc.After(tEx) {
+        ex, ok := m[inc.NodeID]
+        var known *PeerEntry
+        if ok {
+            known = &ex
+        }
+        if err := admitPeer(known, inc); err != nil {
+            logger.Log("WARN", "peers", fmt.Sprintf("Rejected record for %s: %v", inc.NodeID, err))
+            continue
+        }
+        stamp, err := peerStamp(inc)
+        if err != nil {
+            logger.Log("WARN", "peers", fmt.Sprintf("Rejected record for %s: bad last_seen: %v", inc.NodeID, err))
+            continue
+        }
+        if _, err := hlc.Default().Update(stamp); err != nil {
+            Skews().Rejected(inc.NodeID)
+            logger.Log("WARN", "peers", fmt.Sprintf("Rejected record for %s: %v", inc.NodeID, err))
+            continue
+        }
+        inc = checkRole(inc, "peer list")
+
+        // A migrated node announces its old hardware ID; retire that entry in favour of the new one.
+        if inc.LegacyID != "" && inc.LegacyID != inc.NodeID {
+            if old, found := m[inc.LegacyID]; found && canRetireLegacy(old, inc) {
+                logger.Log("INFO", "peers", fmt.Sprintf("Peer %s migrated to NodeID %s", inc.LegacyID, inc.NodeID))
+                delete(m, inc.LegacyID)
+            }
+        }
+
+        if ok {
+            // Compare stamps; a record we hold that is itself beyond the skew bound (saved before
+            // stamps were checked) always gives way
+            tEx, err := peerStamp(ex)
+            if err != nil || hlc.Default().Check(tEx) != nil || stamp.After(tEx) {
                 // Incoming is fresher: use it (updates IP/port too)
                 m[inc.NodeID] = inc
             }
@@ -247,18 +289,29 @@ func mergePeers(existing []PeerEntry, incoming []PeerEntry) []PeerEntry {
     return merged
 }
 
-// Convert time string to time.Time safely
-func parseTime(str string) time.Time {
-	t, _ := time.Parse(time.RFC3339, str)
-	return t
+// parseTime parses an RFC3339 timestamp, with or without fractional seconds.
+func parseTime(str string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", str)
+	}
+	return t, nil
 }
 
-func removePeer(peers []PeerEntry, nodeID string) []PeerEntry {
-	var out []PeerEntry
-	for _, p := range peers {
-		if p.NodeID != nodeID {
-			out = append(out, p)
-		}
-	}
-	return out
+// peerStamp reads p's LastSeen as a hybrid logical clock timestamp.
+func peerStamp(p PeerEntry) (hlc.Timestamp, error) {
+	return hlc.ParseRFC3339(p.LastSeen)
+}
+
+// lastSeen returns the physical time of p's LastSeen and whether it could be read.
+func lastSeen(p PeerEntry) (time.Time, bool) {
+	t, err := parseTime(p.LastSeen)
+	return t, err == nil
+}
+
+// seenAfter reports whether a's LastSeen orders after b's. An unreadable LastSeen orders before any other.
+func seenAfter(a, b PeerEntry) bool {
+	ta, _ := peerStamp(a)
+	tb, _ := peerStamp(b)
+	return ta.After(tb)
 }
@@ -0,0 +1,53 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"atsuko-nexus/src/hlc"
+)
+
+// peerSeen returns a peer record last seen at t.
+func peerSeen(id string, t time.Time) PeerEntry {
+	return PeerEntry{NodeID: id, Type: "default", IPv4: "192.0.2.1", Port: 51613, LastSeen: hlc.FromTime(t).RFC3339()}
+}
+
+func byID(peers []PeerEntry) map[string]PeerEntry {
+	m := make(map[string]PeerEntry, len(peers))
+	for _, p := range peers {
+		m[p.NodeID] = p
+	}
+	return m
+}
+
+func TestMergePeersRefusesFutureStamps(t *testing.T) {
+	now := time.Now()
+	future := now.Add(hlc.MaxSkew() + time.Hour)
+	known := peerSeen("known", now.Add(-time.Minute))
+	existing := []PeerEntry{known}
+
+	incoming := []PeerEntry{
+		peerSeen("known", future),
+		peerSeen("stranger", future),
+		peerSeen("fresh", now),
+	}
+	merged := byID(mergePeers(existing, incoming))
+	if got := merged["known"]; got.LastSeen != known.LastSeen {
+		t.Errorf("far-future record replaced ours: last_seen %s", got.LastSeen)
+	}
+	if _, ok := merged["stranger"]; ok {
+		t.Error("far-future record for a new peer was admitted")
+	}
+	if _, ok := merged["fresh"]; !ok {
+		t.Error("current record for a new peer was refused")
+	}
+	if next := hlc.Default().Now(); next.Time().After(now.Add(hlc.MaxSkew())) {
+		t.Errorf("refused stamps dragged the clock to %s", next.RFC3339())
+	}
+
+	// A newer record within the bound still wins.
+	newer := peerSeen("known", now.Add(time.Second))
+	if got := byID(mergePeers(existing, []PeerEntry{newer}))["known"]; got.LastSeen != newer.LastSeen {
+		t.Errorf("newer record lost: last_seen %s, want %s", got.LastSeen, newer.LastSeen)
+	}
+}
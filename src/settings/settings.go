@@ -152,11 +152,20 @@ network:
   reconnect_interval: 15
   enable_nat_traversal: true
   allow_lan_peers: true
+  # opt-in for mixed-version rollouts: answers unauthenticated plaintext PEERLIST/SYNC
+  legacy_plaintext: false
+  bootstrap_peers: []
+  seed_file: ""
 
 # === PEER TRUST & IDENTITY ===
 identity:
   admin_key: "none"
+  # extra trusted public keys, e.g. - { key: "<hex>", role: "operator" }
+  trusted_keys: []
+  bot_frontend: false
   require_signed_peers: false
+  key_file: "./data/identity/node.key"
+  node_id_mode: "key"
 
 # === STORAGE & PERSISTENCE ===
 storage:
@@ -168,6 +177,10 @@ tasks:
   enable_task_queue: false
   max_concurrent_tasks: 5
   task_timeout_sec: 120
+  max_attempts: 3
+  retry_backoff_sec: 10
+  work_stealing: true
+  lease_timeout_sec: 300
   job_blacklist:
     - "malicious"
     - "spam"
@@ -186,4 +199,39 @@ limits:
   rate_limit_per_minute: 60
   max_messages_per_peer: 100
   cooldown_on_limit_hit: 10
+
+# === GOSSIP ===
+gossip:
+  fanout: 3
+  ttl: 8
+  pull_interval: 30
+  cache_ttl: 600
+
+# === PEER REPUTATION ===
+reputation:
+  initial_score: 50
+  ban_threshold: 10
+  ban_duration: 3600
+
+# === LEADER ELECTION ===
+election:
+  # only roles proven by a trusted key count: "admin", and "bot" vouched for by an operator key
+  eligible_types:
+    - "admin"
+    - "bot"
+  interval: 15
+  liveness_timeout: 300
+  settle_time: 30
+
+# === REPLICATED KEY-VALUE STORE ===
+kv:
+  max_keys: 10000
+  # keys one node may own in its namespace
+  max_keys_per_origin: 1000
+  # seconds a delete is remembered; must outlast any partition
+  tombstone_ttl: 604800
+
+# === CLOCK ===
+clock:
+  max_skew: 60
 `
\ No newline at end of file
@@ -0,0 +1,316 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"atsuko-nexus/src/logger"
+)
+
+const (
+	// stealInterval is how long an idle node waits after a steal attempt that found no work.
+	stealInterval = 10 * time.Second
+	// maxLeaseBatch caps the tasks handed out in one lease reply.
+	maxLeaseBatch = 8
+	// stealPeers is how many peers one steal attempt asks before giving up.
+	stealPeers = 3
+	// reportAttempts is how often a worker tries to hand a result back to the owner.
+	reportAttempts = 3
+)
+
+// ErrStaleLease is returned for a report whose lease has expired or was never granted.
+var ErrStaleLease = errors.New("lease unknown or expired")
+
+// Lease hands one task to a remote worker for Duration. After that the owner queues the task
+// again, so work held by a node that disappears is not lost. The lease carries a duration rather
+// than an expiry time so that the worker measures it on its own clock.
+type Lease struct {
+	ID       string        `json:"id"`
+	Task     Task          `json:"task"`
+	Duration time.Duration `json:"duration"`
+	Owner    string        `json:"-"` // set by the worker to the node it took the lease from
+
+	deadline time.Time // set by the worker: when it received the lease plus Duration
+}
+
+// Report carries the outcome of a leased task back to the node that owns it. Refused means the
+// worker did not run the task: it lacks a handler, its blacklist matched, or, when Busy is also
+// set, it had no worker free by the time the lease arrived and may take the task later.
+type Report struct {
+	LeaseID string          `json:"lease_id"`
+	TaskID  string          `json:"task_id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Refused bool            `json:"refused,omitempty"`
+	Busy    bool            `json:"busy,omitempty"`
+}
+
+// Remote connects a Queue to other nodes. Peers returns the NodeIDs worth stealing from, best
+// first; Steal asks one of them to lease us up to capacity tasks of the given types; Report
+// returns the outcome of a leased task to its owner.
+type Remote interface {
+	Peers() []string
+	Steal(nodeID string, capacity int, types []string) ([]Lease, error)
+	Report(nodeID string, r Report) error
+}
+
+// HandleSteal leases up to capacity queued tasks of the given types to worker. Only surplus work
+// is lent: tasks our own free workers would start right away stay here, as do tasks worker has
+// refused before. A lease does not use up an attempt until it is reported or expires.
+func (q *Queue) HandleSteal(worker string, capacity int, types []string) []Lease {
+	if !q.cfg.Stealing || q.isPaused() {
+		return nil
+	}
+	capacity = min(capacity, maxLeaseBatch)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.started || capacity <= 0 {
+		return nil
+	}
+	wanted := make(map[string]bool, len(types))
+	for _, jt := range types {
+		wanted[jt] = true
+	}
+	now := time.Now()
+	var ready []*Task
+	for _, t := range q.tasks {
+		if t.ready(now) && wanted[t.Type] && !t.refusedBy(worker) {
+			ready = append(ready, t)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Created < ready[j].Created })
+	if free := q.cfg.Workers - q.running; free > 0 {
+		ready = ready[min(free, len(ready)):]
+	}
+
+	var leases []Lease
+	for _, t := range ready[:min(capacity, len(ready))] {
+		id, err := newID()
+		if err != nil {
+			break
+		}
+		t.Status = StatusLeased
+		t.Worker, t.LeaseID = worker, id
+		t.LeaseExpires = now.Add(q.cfg.LeaseTimeout).UTC().Format(time.RFC3339Nano)
+		t.Updated = now.UTC().Format(time.RFC3339Nano)
+		q.save(t)
+		leases = append(leases, Lease{ID: id, Task: *t, Duration: q.cfg.LeaseTimeout})
+	}
+	if len(leases) > 0 {
+		logger.Log("INFO", "tasks", fmt.Sprintf("Leased %d tasks to %s", len(leases), worker))
+	}
+	return leases
+}
+
+// HandleReport applies the outcome of a leased task reported by worker. Reports for leases that
+// expired or belong to another worker are refused; the task has already been queued again. A
+// remote failure is always retried while attempts remain: only this node decides that a task
+// cannot succeed. A refusal hands the task back without using an attempt.
+func (q *Queue) HandleReport(worker string, r Report) error {
+	q.mu.Lock()
+	defer func() {
+		q.mu.Unlock()
+		q.signal()
+	}()
+	t, ok := q.tasks[r.TaskID]
+	if !ok || t.Status != StatusLeased || t.LeaseID != r.LeaseID || t.Worker != worker {
+		return ErrStaleLease
+	}
+	if r.Refused {
+		logger.Log("DEBUG", "tasks", fmt.Sprintf("Task %s (%s) refused by %s: %s", t.ID, t.Type, worker, r.Error))
+		t.Status = StatusQueued
+		if !r.Busy {
+			t.RefusedBy = append(t.RefusedBy, worker)
+		}
+		t.Worker, t.LeaseID, t.LeaseExpires = "", "", ""
+		t.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+		q.save(t)
+		return nil
+	}
+	var err error
+	if r.Error != "" {
+		err = errors.New(r.Error)
+	}
+	logger.Log("DEBUG", "tasks", fmt.Sprintf("Task %s (%s) reported by %s", t.ID, t.Type, worker))
+	t.Attempts++
+	q.settle(t, r.Result, err)
+	return nil
+}
+
+// expireLeases queues again, or fails once out of attempts, every task whose lease ran out.
+func (q *Queue) expireLeases() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, t := range q.tasks {
+		if t.Status != StatusLeased {
+			continue
+		}
+		expires, err := time.Parse(time.RFC3339Nano, t.LeaseExpires)
+		if err == nil && now.Before(expires) {
+			continue
+		}
+		logger.Log("WARN", "tasks", fmt.Sprintf("Lease on task %s held by %s expired", t.ID, t.Worker))
+		t.Attempts++
+		q.settle(t, nil, fmt.Errorf("lease held by %s expired", t.Worker))
+	}
+}
+
+// steal asks peers for work when every local task is running or waiting out a backoff and workers
+// are still free. It runs in the background so the dispatcher is never held up by the network.
+func (q *Queue) steal(ctx context.Context) {
+	if q.remote == nil || !q.cfg.Stealing {
+		return
+	}
+	q.mu.Lock()
+	free := q.cfg.Workers - q.running
+	if q.stealing || free <= 0 || time.Since(q.lastSteal) < stealInterval || q.hasReady(time.Now()) {
+		q.mu.Unlock()
+		return
+	}
+	q.stealing = true
+	q.mu.Unlock()
+
+	go func() {
+		leases := q.stealFromPeers(free)
+		q.mu.Lock()
+		q.stealing = false
+		q.lastSteal = time.Now()
+		q.mu.Unlock()
+		for _, l := range leases {
+			q.runStolen(ctx, l)
+		}
+	}()
+}
+
+// stealFromPeers asks up to stealPeers peers, best first, until one leases us work.
+func (q *Queue) stealFromPeers(capacity int) []Lease {
+	types := registeredTypes()
+	if len(types) == 0 {
+		return nil
+	}
+	peers := q.remote.Peers()
+	for _, peer := range peers[:min(stealPeers, len(peers))] {
+		leases, err := q.remote.Steal(peer, capacity, types)
+		if err != nil {
+			logger.Log("DEBUG", "tasks", fmt.Sprintf("Steal from %s failed: %v", peer, err))
+			continue
+		}
+		if len(leases) == 0 {
+			continue
+		}
+		now := time.Now()
+		for i := range leases {
+			leases[i].Owner = peer
+			leases[i].deadline = now.Add(leases[i].Duration)
+		}
+		logger.Log("INFO", "tasks", fmt.Sprintf("Took %d tasks from %s", len(leases), peer))
+		return leases[:min(capacity, len(leases))]
+	}
+	return nil
+}
+
+// runStolen runs a leased task on a local worker and reports the outcome to its owner.
+func (q *Queue) runStolen(ctx context.Context, l Lease) {
+	report := Report{LeaseID: l.ID, TaskID: l.Task.ID}
+
+	h, ok := handlerFor(l.Task.Type)
+	if !ok {
+		report.Error, report.Refused = fmt.Sprintf("%v %q", ErrUnknownType, l.Task.Type), true
+		go q.report(ctx, l, report)
+		return
+	}
+	if word, hit := blacklisted(l.Task.Type, l.Task.Payload); hit {
+		report.Error, report.Refused = fmt.Sprintf("%v (%q)", ErrBlacklisted, word), true
+		go q.report(ctx, l, report)
+		return
+	}
+
+	// Local tasks may have taken the workers that were free when we asked for work.
+	q.mu.Lock()
+	if q.running >= q.cfg.Workers {
+		q.mu.Unlock()
+		report.Error, report.Refused, report.Busy = ErrNoCapacity.Error(), true, true
+		go q.report(ctx, l, report)
+		return
+	}
+	q.running++
+	q.stolen[l.ID] = l
+	q.mu.Unlock()
+
+	q.workers.Add(1)
+	go func() {
+		defer q.workers.Done()
+		defer func() {
+			q.mu.Lock()
+			delete(q.stolen, l.ID)
+			q.mu.Unlock()
+		}()
+
+		// Stop early enough that the result reaches the owner before the lease runs out.
+		timeout := q.cfg.Timeout
+		if l.Duration > 0 {
+			timeout = min(timeout, time.Until(l.deadline))
+		}
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		result, err := q.run(runCtx, h, l.Task, q.release)
+		if ctx.Err() != nil {
+			return // shutting down; the owner re-queues the task when the lease expires
+		}
+		report.Result = result
+		if err != nil {
+			report.Error = err.Error()
+		}
+		q.report(ctx, l, report)
+	}()
+}
+
+// report hands r to the owner of l, retrying a few times before giving up; the owner then
+// re-queues the task when the lease expires.
+func (q *Queue) report(ctx context.Context, l Lease, r Report) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := q.remote.Report(l.Owner, r)
+		if err == nil {
+			return
+		}
+		if attempt == reportAttempts || errors.Is(err, ErrStaleLease) {
+			logger.Log("WARN", "tasks", fmt.Sprintf("Could not report task %s to %s: %v", l.Task.ID, l.Owner, err))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// hasReady reports whether a local task is waiting for a worker. Call with q.mu held.
+func (q *Queue) hasReady(now time.Time) bool {
+	for _, t := range q.tasks {
+		if t.ready(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredTypes lists the job types this node has handlers for.
+func registeredTypes() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	out := make([]string, 0, len(handlers))
+	for jt := range handlers {
+		out = append(out, jt)
+	}
+	sort.Strings(out)
+	return out
+}
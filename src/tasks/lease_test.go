@@ -0,0 +1,370 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memCluster connects Queues in process. A node marked down refuses every request, as if it had
+// left the network.
+type memCluster struct {
+	mu     sync.Mutex
+	queues map[string]*Queue
+	down   map[string]bool
+}
+
+func newMemCluster() *memCluster {
+	return &memCluster{queues: make(map[string]*Queue), down: make(map[string]bool)}
+}
+
+// add creates a queue named id on the cluster.
+func (c *memCluster) add(id string, cfg Config) *Queue {
+	q := New(cfg)
+	q.remote = memRemote{cluster: c, self: id}
+	c.mu.Lock()
+	c.queues[id] = q
+	c.mu.Unlock()
+	return q
+}
+
+func (c *memCluster) setDown(id string, down bool) {
+	c.mu.Lock()
+	c.down[id] = down
+	c.mu.Unlock()
+}
+
+// memRemote is one queue's view of a memCluster.
+type memRemote struct {
+	cluster *memCluster
+	self    string
+}
+
+func (r memRemote) Peers() []string {
+	r.cluster.mu.Lock()
+	defer r.cluster.mu.Unlock()
+	var out []string
+	for id := range r.cluster.queues {
+		if id != r.self && !r.cluster.down[id] {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r memRemote) queue(id string) (*Queue, error) {
+	r.cluster.mu.Lock()
+	defer r.cluster.mu.Unlock()
+	q, ok := r.cluster.queues[id]
+	if !ok || r.cluster.down[id] {
+		return nil, fmt.Errorf("node %s unreachable", id)
+	}
+	return q, nil
+}
+
+func (r memRemote) Steal(id string, capacity int, types []string) ([]Lease, error) {
+	q, err := r.queue(id)
+	if err != nil {
+		return nil, err
+	}
+	// Round-trip through JSON as the wire would, so nothing unexported leaks across.
+	data, err := json.Marshal(q.HandleSteal(r.self, capacity, types))
+	if err != nil {
+		return nil, err
+	}
+	var leases []Lease
+	return leases, json.Unmarshal(data, &leases)
+}
+
+func (r memRemote) Report(id string, rep Report) error {
+	q, err := r.queue(id)
+	if err != nil {
+		return err
+	}
+	return q.HandleReport(r.self, rep)
+}
+
+// Job types shared by the tests. Handlers are process-wide, so every queue can run them.
+const (
+	jobEcho  = "test.echo"
+	jobBlock = "test.block"
+	jobSlow  = "test.slow"
+)
+
+var unblock = make(chan struct{})
+
+func init() {
+	Register(jobEcho, func(_ context.Context, payload json.RawMessage) (json.RawMessage, error) {
+		return payload, nil
+	})
+	Register(jobBlock, func(ctx context.Context, _ json.RawMessage) (json.RawMessage, error) {
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+		}
+		return nil, ctx.Err()
+	})
+	Register(jobSlow, func(_ context.Context, _ json.RawMessage) (json.RawMessage, error) {
+		time.Sleep(200 * time.Millisecond)
+		return json.RawMessage(`"late"`), nil
+	})
+}
+
+func testConfig() Config {
+	return Config{
+		Workers:      1,
+		Timeout:      5 * time.Second,
+		MaxAttempts:  3,
+		RetryBackoff: time.Millisecond,
+		Stealing:     true,
+		LeaseTimeout: time.Minute,
+	}
+}
+
+// busy marks q as started with every worker occupied, so all of its ready tasks are surplus and
+// may be lent. No dispatcher runs, so the test drives the queue directly.
+func busy(q *Queue) {
+	q.mu.Lock()
+	q.started = true
+	q.running = q.cfg.Workers
+	q.mu.Unlock()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mustEnqueue(t *testing.T, q *Queue, jobType string, payload any) Task {
+	t.Helper()
+	task, err := q.Enqueue(jobType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestIdleNodeStealsAndReports(t *testing.T) {
+	c := newMemCluster()
+	owner := c.add("owner", testConfig())
+	workerCfg := testConfig()
+	workerCfg.Workers = 2
+	worker := c.add("worker", workerCfg)
+
+	ctx := context.Background()
+	if err := owner.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Stop(ctx)
+	blocker := mustEnqueue(t, owner, jobBlock, nil)
+	defer func() { unblock <- struct{}{} }()
+	waitFor(t, "owner to start its own task", func() bool {
+		got, _ := owner.Get(blocker.ID)
+		return got.Status == StatusRunning
+	})
+	a := mustEnqueue(t, owner, jobEcho, "a")
+	b := mustEnqueue(t, owner, jobEcho, "b")
+
+	if err := worker.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Stop(ctx)
+
+	for _, want := range []Task{a, b} {
+		waitFor(t, "task "+want.ID+" to finish", func() bool {
+			got, _ := owner.Get(want.ID)
+			return got.Finished()
+		})
+		got, _ := owner.Get(want.ID)
+		if got.Status != StatusDone || string(got.Result) != string(want.Payload) {
+			t.Errorf("task %s: status %s result %s, want done with %s", got.ID, got.Status, got.Result, want.Payload)
+		}
+		if got.Attempts != 1 || got.Worker != "" || got.LeaseID != "" {
+			t.Errorf("task %s: attempts %d worker %q lease %q after report", got.ID, got.Attempts, got.Worker, got.LeaseID)
+		}
+	}
+	waitFor(t, "worker to free its slots", func() bool { return worker.Stats().Stolen == 0 })
+}
+
+func TestRemoteReportCannotFailTask(t *testing.T) {
+	c := newMemCluster()
+	owner := c.add("owner", testConfig())
+	busy(owner)
+	task := mustEnqueue(t, owner, jobEcho, "x")
+
+	leases := owner.HandleSteal("worker", 4, []string{jobEcho})
+	if len(leases) != 1 {
+		t.Fatalf("leased %d tasks, want 1", len(leases))
+	}
+	l := leases[0]
+	if got, _ := owner.Get(task.ID); got.Attempts != 0 {
+		t.Errorf("lease used an attempt: %d", got.Attempts)
+	}
+	if err := owner.HandleReport("intruder", Report{LeaseID: l.ID, TaskID: task.ID}); !errors.Is(err, ErrStaleLease) {
+		t.Errorf("report from another node: %v, want ErrStaleLease", err)
+	}
+
+	// A report claiming the failure is permanent is only a failure like any other.
+	report := json.RawMessage(`{"lease_id":"` + l.ID + `","task_id":"` + task.ID + `","error":"boom","permanent":true}`)
+	var r Report
+	if err := json.Unmarshal(report, &r); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.HandleReport("worker", r); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := owner.Get(task.ID)
+	if got.Status != StatusQueued || got.Attempts != 1 {
+		t.Errorf("after remote failure: status %s attempts %d, want queued with 1", got.Status, got.Attempts)
+	}
+	if err := owner.HandleReport("worker", r); !errors.Is(err, ErrStaleLease) {
+		t.Errorf("second report on a settled lease: %v, want ErrStaleLease", err)
+	}
+}
+
+func TestRefusedLeaseKeepsAttempts(t *testing.T) {
+	c := newMemCluster()
+	owner := c.add("owner", testConfig())
+	busy(owner)
+	task := mustEnqueue(t, owner, jobEcho, "x")
+
+	l := owner.HandleSteal("picky", 4, []string{jobEcho})[0]
+	if err := owner.HandleReport("picky", Report{LeaseID: l.ID, TaskID: task.ID, Error: "blacklisted", Refused: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := owner.Get(task.ID)
+	if got.Status != StatusQueued || got.Attempts != 0 {
+		t.Errorf("after refusal: status %s attempts %d, want queued with 0", got.Status, got.Attempts)
+	}
+	if again := owner.HandleSteal("picky", 4, []string{jobEcho}); len(again) != 0 {
+		t.Errorf("task leased again to the node that refused it")
+	}
+	if other := owner.HandleSteal("other", 4, []string{jobEcho}); len(other) != 1 {
+		t.Errorf("refused task not lent to another node")
+	}
+}
+
+func TestExpiredLeaseIsQueuedAgain(t *testing.T) {
+	c := newMemCluster()
+	cfg := testConfig()
+	cfg.LeaseTimeout = 50 * time.Millisecond
+	cfg.MaxAttempts = 2
+	owner := c.add("owner", cfg)
+	busy(owner)
+	task := mustEnqueue(t, owner, jobEcho, "x")
+
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		leases := owner.HandleSteal("worker", 4, []string{jobEcho})
+		if len(leases) != 1 {
+			t.Fatalf("attempt %d: leased %d tasks, want 1", attempt, len(leases))
+		}
+		if leases[0].Duration != cfg.LeaseTimeout {
+			t.Errorf("lease duration %s, want %s", leases[0].Duration, cfg.LeaseTimeout)
+		}
+		owner.expireLeases()
+		if got, _ := owner.Get(task.ID); got.Status != StatusLeased {
+			t.Fatalf("lease expired early: %s", got.Status)
+		}
+		time.Sleep(2 * cfg.LeaseTimeout)
+		owner.expireLeases()
+
+		got, _ := owner.Get(task.ID)
+		if got.Attempts != attempt || got.LeaseID != "" {
+			t.Errorf("attempt %d: attempts %d lease %q after expiry", attempt, got.Attempts, got.LeaseID)
+		}
+		want := StatusQueued
+		if attempt == cfg.MaxAttempts {
+			want = StatusFailed
+		}
+		if got.Status != want {
+			t.Errorf("attempt %d: status %s, want %s", attempt, got.Status, want)
+		}
+		if err := owner.HandleReport("worker", Report{LeaseID: leases[0].ID, TaskID: task.ID}); !errors.Is(err, ErrStaleLease) {
+			t.Errorf("report after expiry: %v, want ErrStaleLease", err)
+		}
+		// Let the retry backoff pass before the next lease.
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOwnerLeavesWhileLeaseOpen(t *testing.T) {
+	c := newMemCluster()
+	cfg := testConfig()
+	cfg.LeaseTimeout = 300 * time.Millisecond
+	owner := c.add("owner", cfg)
+	busy(owner)
+	worker := c.add("worker", testConfig())
+	task := mustEnqueue(t, owner, jobSlow, nil)
+
+	leases := worker.stealFromPeers(1)
+	if len(leases) != 1 || leases[0].Owner != "owner" {
+		t.Fatalf("stole %v, want one lease from owner", leases)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker.runStolen(ctx, leases[0])
+	c.setDown("owner", true)
+
+	// The worker gives up reporting and frees its slot.
+	waitFor(t, "worker to give up on the owner", func() bool {
+		s := worker.Stats()
+		worker.mu.Lock()
+		defer worker.mu.Unlock()
+		return s.Stolen == 0 && worker.running == 0
+	})
+
+	// The owner re-queues the task once the lease runs out, and it can then run elsewhere.
+	c.setDown("owner", false)
+	time.Sleep(cfg.LeaseTimeout)
+	owner.expireLeases()
+	got, _ := owner.Get(task.ID)
+	if got.Status != StatusQueued || got.Attempts != 1 {
+		t.Fatalf("after owner returned: status %s attempts %d, want queued with 1", got.Status, got.Attempts)
+	}
+}
+
+func TestStolenLeaseBeyondCapacityIsHandedBack(t *testing.T) {
+	c := newMemCluster()
+	owner := c.add("owner", testConfig())
+	busy(owner)
+	worker := c.add("worker", testConfig())
+	task := mustEnqueue(t, owner, jobEcho, "x")
+
+	leases := worker.stealFromPeers(1)
+	if len(leases) != 1 {
+		t.Fatalf("stole %d leases, want 1", len(leases))
+	}
+	// A local task took the only worker while the steal was in flight.
+	busy(worker)
+	worker.runStolen(context.Background(), leases[0])
+
+	waitFor(t, "the lease to be handed back", func() bool {
+		got, _ := owner.Get(task.ID)
+		return got.Status == StatusQueued
+	})
+	worker.mu.Lock()
+	running := worker.running
+	worker.mu.Unlock()
+	if running != worker.cfg.Workers || worker.Stats().Stolen != 0 {
+		t.Errorf("worker ran the lease over capacity: %d running", running)
+	}
+	got, _ := owner.Get(task.ID)
+	if got.Attempts != 0 || len(got.RefusedBy) != 0 {
+		t.Errorf("handed-back lease: attempts %d refused by %v, want neither", got.Attempts, got.RefusedBy)
+	}
+	if again := owner.HandleSteal("worker", 1, []string{jobEcho}); len(again) != 1 {
+		t.Errorf("task not lent again to the worker that was busy")
+	}
+}
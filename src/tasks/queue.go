@@ -0,0 +1,473 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/db"
+	"atsuko-nexus/src/logger"
+)
+
+const (
+	// finishedRetention is how long done and failed tasks are kept for inspection.
+	finishedRetention = 24 * time.Hour
+	// maxRetryDelay caps the backoff between attempts however many have failed.
+	maxRetryDelay = time.Hour
+	// pollInterval is how often the dispatcher looks for tasks whose backoff has elapsed.
+	pollInterval = time.Second
+)
+
+// Config tunes a Queue.
+type Config struct {
+	Workers      int           // tasks run at once
+	Timeout      time.Duration // limit on a single attempt
+	MaxAttempts  int           // attempts before a task fails for good
+	RetryBackoff time.Duration // delay before the first retry; doubles after each failure
+	Stealing     bool          // lend surplus work to idle peers and take theirs when idle
+	LeaseTimeout time.Duration // how long a remote worker may hold a leased task
+}
+
+// ConfigFromSettings reads the tasks.* settings.
+func ConfigFromSettings() Config {
+	return Config{
+		Workers:      maxConcurrent(),
+		Timeout:      taskTimeout(),
+		MaxAttempts:  maxAttempts(),
+		RetryBackoff: retryBackoff(),
+		Stealing:     workStealing(),
+		LeaseTimeout: leaseTimeout(),
+	}
+}
+
+// Stats summarises the queue for status displays.
+type Stats struct {
+	Queued  int
+	Running int
+	Done    int
+	Failed  int
+	Leased  int // our tasks held by other nodes
+	Stolen  int // other nodes' tasks we are running
+}
+
+// Queue holds tasks and runs them on a bounded worker pool.
+type Queue struct {
+	cfg     Config
+	persist bool
+	paused  func() bool
+	remote  Remote
+
+	mu        sync.Mutex
+	tasks     map[string]*Task
+	running   int
+	started   bool
+	stolen    map[string]Lease
+	lastSteal time.Time
+	stealing  bool
+	wake      chan struct{}
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+var (
+	queue     *Queue
+	queueOnce sync.Once
+)
+
+// New returns an in-memory Queue. Default returns the persistent, process-wide one.
+func New(cfg Config) *Queue {
+	return &Queue{
+		cfg:    cfg,
+		tasks:  make(map[string]*Task),
+		stolen: make(map[string]Lease),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Default returns the process-wide queue, loading queued work from the database on first use.
+// Tasks that were running when the node stopped are queued again.
+func Default() *Queue {
+	queueOnce.Do(func() {
+		queue = New(ConfigFromSettings())
+		queue.persist = true
+		if _, err := db.Open(); err != nil {
+			logger.Log("ERROR", "tasks", "Task queue running in memory only: "+err.Error())
+			queue.persist = false
+			return
+		}
+		requeued := 0
+		err := db.ForEach(db.BucketTasks, func(key string, value []byte) error {
+			var t Task
+			if err := json.Unmarshal(value, &t); err != nil || t.ID == "" {
+				return nil
+			}
+			if t.Status == StatusRunning {
+				t.Status = StatusQueued
+				requeued++
+			}
+			queue.tasks[t.ID] = &t
+			return nil
+		})
+		if err != nil {
+			logger.Log("ERROR", "tasks", "Failed to load task queue: "+err.Error())
+		}
+		logger.Log("DEBUG", "tasks", fmt.Sprintf("Loaded %d tasks (%d interrupted and re-queued)", len(queue.tasks), requeued))
+	})
+	return queue
+}
+
+// Service returns the process-wide queue as a lifecycle service. While paused reports true no new
+// tasks are started, lent or stolen; running ones finish normally. remote connects the queue to
+// other nodes for work stealing and may be nil.
+func Service(paused func() bool, remote Remote) *Queue {
+	q := Default()
+	q.paused = paused
+	q.remote = remote
+	return q
+}
+
+// Enqueue adds a job of jobType with payload encoded as JSON and returns the queued task.
+func (q *Queue) Enqueue(jobType string, payload any) (Task, error) {
+	if q.persist && !enabled() {
+		return Task{}, ErrDisabled
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Task{}, err
+	}
+	if word, hit := blacklisted(jobType, data); hit {
+		return Task{}, fmt.Errorf("%w (%q)", ErrBlacklisted, word)
+	}
+	if _, ok := handlerFor(jobType); !ok {
+		return Task{}, fmt.Errorf("%w %q", ErrUnknownType, jobType)
+	}
+	id, err := newID()
+	if err != nil {
+		return Task{}, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	t := &Task{
+		ID:          id,
+		Type:        jobType,
+		Payload:     data,
+		Status:      StatusQueued,
+		MaxAttempts: q.cfg.MaxAttempts,
+		Created:     now,
+		Updated:     now,
+	}
+
+	q.mu.Lock()
+	q.tasks[id] = t
+	q.save(t)
+	out := *t
+	q.mu.Unlock()
+
+	logger.Log("DEBUG", "tasks", fmt.Sprintf("Queued %s task %s", jobType, id))
+	q.signal()
+	return out, nil
+}
+
+// Get returns the task with id.
+func (q *Queue) Get(id string) (Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t, ok := q.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *t, true
+}
+
+// List returns every known task, oldest first.
+func (q *Queue) List() []Task {
+	q.mu.Lock()
+	out := make([]Task, 0, len(q.tasks))
+	for _, t := range q.tasks {
+		out = append(out, *t)
+	}
+	q.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	return out
+}
+
+// Stats counts tasks by state.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var s Stats
+	for _, t := range q.tasks {
+		switch t.Status {
+		case StatusQueued:
+			s.Queued++
+		case StatusRunning:
+			s.Running++
+		case StatusDone:
+			s.Done++
+		case StatusFailed:
+			s.Failed++
+		case StatusLeased:
+			s.Leased++
+		}
+	}
+	s.Stolen = len(q.stolen)
+	return s
+}
+
+func (q *Queue) Name() string { return "task queue" }
+
+// Start runs the dispatcher until Stop. It does nothing when tasks.enable_task_queue is off.
+func (q *Queue) Start(ctx context.Context) error {
+	if q.persist && !enabled() {
+		logger.Log("INFO", "tasks", "Task queue disabled (tasks.enable_task_queue)")
+		return nil
+	}
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.done = make(chan struct{})
+	q.mu.Lock()
+	q.started = true
+	q.mu.Unlock()
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		lastPrune := time.Now()
+		for {
+			q.expireLeases()
+			if !q.isPaused() {
+				q.dispatch(ctx)
+				q.steal(ctx)
+			}
+			if time.Since(lastPrune) > time.Hour {
+				q.prune()
+				lastPrune = time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+	logger.Log("INFO", "tasks", fmt.Sprintf("Task queue started with %d workers", q.cfg.Workers))
+	return nil
+}
+
+// Stop ends the dispatcher, cancels running tasks and waits for their workers to return.
+// Cancelled tasks are queued again for the next start.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+	q.mu.Lock()
+	q.started = false
+	q.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		<-q.done
+		q.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isPaused reports whether task processing is paused.
+func (q *Queue) isPaused() bool {
+	return q.paused != nil && q.paused()
+}
+
+// signal wakes the dispatcher without blocking.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch starts ready tasks, oldest first, until every worker is busy.
+func (q *Queue) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		t, h, ok := q.next()
+		if !ok {
+			return
+		}
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			result, err := q.run(ctx, h, t, q.release)
+			q.finish(ctx, t.ID, result, err)
+		}()
+	}
+}
+
+// next claims the oldest ready task for a free worker. Tasks that can no longer run, because their
+// handler is gone or they now match the blacklist, are failed on the way.
+func (q *Queue) next() (Task, Handler, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running >= q.cfg.Workers {
+		return Task{}, nil, false
+	}
+	now := time.Now()
+	var ready []*Task
+	for _, t := range q.tasks {
+		if t.ready(now) {
+			ready = append(ready, t)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Created < ready[j].Created })
+
+	for _, t := range ready {
+		if word, hit := blacklisted(t.Type, t.Payload); hit {
+			q.fail(t, fmt.Errorf("%w (%q)", ErrBlacklisted, word))
+			continue
+		}
+		h, ok := handlerFor(t.Type)
+		if !ok {
+			q.fail(t, fmt.Errorf("%w %q", ErrUnknownType, t.Type))
+			continue
+		}
+		t.Status = StatusRunning
+		t.Attempts++
+		t.Updated = now.UTC().Format(time.RFC3339Nano)
+		q.save(t)
+		q.running++
+		return *t, h, true
+	}
+	return Task{}, nil, false
+}
+
+// run calls h with the task timeout applied. A handler that ignores its context is abandoned when
+// the timeout fires so that it cannot hold the task forever, but it keeps its worker slot: release
+// is called only once h has actually returned, so abandoned handlers cannot pile up past Workers.
+func (q *Queue) run(ctx context.Context, h Handler, t Task, release func()) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.Timeout)
+	defer cancel()
+
+	type outcome struct {
+		result json.RawMessage
+		err    error
+	}
+	out := make(chan outcome, 1)
+	go func() {
+		defer release()
+		defer func() {
+			if r := recover(); r != nil {
+				out <- outcome{err: Permanent(fmt.Errorf("handler panicked: %v", r))}
+			}
+		}()
+		result, err := h(ctx, t.Payload)
+		out <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-out:
+		return o.result, o.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timed out after %s", q.cfg.Timeout)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// release frees a worker slot once the handler holding it has returned.
+func (q *Queue) release() {
+	q.mu.Lock()
+	q.running--
+	q.mu.Unlock()
+	q.signal()
+}
+
+// finish records the outcome of one local attempt. The worker slot is released separately, by run.
+func (q *Queue) finish(ctx context.Context, id string, result json.RawMessage, err error) {
+	q.mu.Lock()
+	defer func() {
+		q.mu.Unlock()
+		q.signal()
+	}()
+	t, ok := q.tasks[id]
+	if !ok {
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: the attempt does not count and the task runs again after a restart.
+		t.Status = StatusQueued
+		t.Attempts--
+		q.save(t)
+		return
+	}
+	q.settle(t, result, err)
+}
+
+// settle moves t to done, back to queued for a retry after backoff, or to failed, depending on the
+// outcome of its latest attempt. Call with q.mu held.
+func (q *Queue) settle(t *Task, result json.RawMessage, err error) {
+	now := time.Now().UTC()
+	t.Updated = now.Format(time.RFC3339Nano)
+	t.Worker, t.LeaseID, t.LeaseExpires = "", "", ""
+
+	switch {
+	case err == nil:
+		t.Status, t.Result, t.Error = StatusDone, result, ""
+		logger.Log("DEBUG", "tasks", fmt.Sprintf("Task %s (%s) done after %d attempt(s)", t.ID, t.Type, t.Attempts))
+	case isPermanent(err) || t.Attempts >= t.MaxAttempts:
+		q.fail(t, err)
+		return
+	default:
+		delay := q.cfg.RetryBackoff << (t.Attempts - 1)
+		if delay <= 0 || delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		t.Status, t.Error = StatusQueued, err.Error()
+		t.NotBefore = now.Add(delay).Format(time.RFC3339Nano)
+		logger.Log("WARN", "tasks", fmt.Sprintf("Task %s (%s) attempt %d failed: %v; retrying in %s", t.ID, t.Type, t.Attempts, err, delay))
+	}
+	q.save(t)
+}
+
+// fail marks t as failed for good. Call with q.mu held.
+func (q *Queue) fail(t *Task, err error) {
+	t.Status, t.Error = StatusFailed, err.Error()
+	t.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	q.save(t)
+	logger.Log("ERROR", "tasks", fmt.Sprintf("Task %s (%s) failed: %v", t.ID, t.Type, err))
+}
+
+// prune forgets finished tasks older than finishedRetention.
+func (q *Queue) prune() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cutoff := time.Now().Add(-finishedRetention)
+	for id, t := range q.tasks {
+		updated, err := time.Parse(time.RFC3339Nano, t.Updated)
+		if t.Finished() && (err != nil || updated.Before(cutoff)) {
+			delete(q.tasks, id)
+			if q.persist {
+				_ = db.Delete(db.BucketTasks, id)
+			}
+		}
+	}
+}
+
+// save writes t to the database for the persistent queue. Call with q.mu held.
+func (q *Queue) save(t *Task) {
+	if !q.persist {
+		return
+	}
+	if err := db.PutJSON(db.BucketTasks, t.ID, t); err != nil {
+		logger.Log("ERROR", "tasks", fmt.Sprintf("Failed to save task %s: %v", t.ID, err))
+	}
+}
@@ -0,0 +1,182 @@
+// Package tasks runs background jobs through a persistent queue. Other packages register a Handler
+// per job type and enqueue work; a worker pool bounded by tasks.max_concurrent_tasks runs it with a
+// per-task timeout and retries failures with exponential backoff. Queued work lives in the database,
+// so it survives restarts, and jobs matching tasks.job_blacklist are refused at the door. With a
+// Remote attached, idle nodes lease surplus work from busy ones and report the results back.
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"atsuko-nexus/src/settings"
+)
+
+// Task states.
+const (
+	StatusQueued  = "queued"  // waiting to run, possibly until NotBefore
+	StatusRunning = "running" // a worker is running it
+	StatusLeased  = "leased"  // a remote worker holds it until LeaseExpires
+	StatusDone    = "done"    // finished; Result holds the output
+	StatusFailed  = "failed"  // gave up; Error holds the last failure
+)
+
+var (
+	ErrDisabled    = errors.New("task queue disabled")
+	ErrBlacklisted = errors.New("job matches tasks.job_blacklist")
+	ErrUnknownType = errors.New("no handler registered for job type")
+	ErrNoCapacity  = errors.New("no worker free")
+)
+
+// Task is one unit of work and its progress.
+type Task struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	NotBefore   string          `json:"not_before,omitempty"`
+	Created     string          `json:"created"`
+	Updated     string          `json:"updated"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+
+	// Set while the task is leased to another node.
+	Worker       string `json:"worker,omitempty"`
+	LeaseID      string `json:"lease_id,omitempty"`
+	LeaseExpires string `json:"lease_expires,omitempty"`
+
+	// Workers that refused the task; it is not leased to them again.
+	RefusedBy []string `json:"refused_by,omitempty"`
+}
+
+// Finished reports whether t has reached a final state.
+func (t Task) Finished() bool {
+	return t.Status == StatusDone || t.Status == StatusFailed
+}
+
+// refusedBy reports whether worker has refused t before.
+func (t Task) refusedBy(worker string) bool {
+	for _, w := range t.RefusedBy {
+		if w == worker {
+			return true
+		}
+	}
+	return false
+}
+
+// ready reports whether t may start now.
+func (t Task) ready(now time.Time) bool {
+	if t.Status != StatusQueued {
+		return false
+	}
+	nb, err := time.Parse(time.RFC3339Nano, t.NotBefore)
+	return err != nil || !now.Before(nb)
+}
+
+// Handler runs one job. It must stop promptly once ctx is cancelled, which happens when the task
+// times out or the node shuts down. A returned error is retried unless wrapped with Permanent.
+type Handler func(ctx context.Context, payload json.RawMessage) (json.RawMessage, error)
+
+var (
+	handlers   = make(map[string]Handler)
+	handlersMu sync.RWMutex
+)
+
+// Register installs h for jobType, replacing any earlier handler. Call it during startup, before
+// the queue service starts running tasks.
+func Register(jobType string, h Handler) {
+	handlersMu.Lock()
+	handlers[jobType] = h
+	handlersMu.Unlock()
+}
+
+// handlerFor returns the handler for jobType, if any.
+func handlerFor(jobType string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	h, ok := handlers[jobType]
+	return h, ok
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the queue fails the task immediately instead of retrying it.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// blacklisted reports the tasks.job_blacklist entry that jobType or payload contains, if any.
+// Matching is a case-insensitive substring test.
+func blacklisted(jobType string, payload []byte) (string, bool) {
+	list, _ := settings.Get("tasks.job_blacklist").([]interface{})
+	haystack := strings.ToLower(jobType + "\n" + string(payload))
+	for _, item := range list {
+		word := strings.ToLower(strings.TrimSpace(fmt.Sprint(item)))
+		if word != "" && strings.Contains(haystack, word) {
+			return word, true
+		}
+	}
+	return "", false
+}
+
+// newID returns a random task ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Settings helpers.
+
+func enabled() bool {
+	on, _ := settings.Get("tasks.enable_task_queue").(bool)
+	return on
+}
+
+func maxConcurrent() int { return intSetting("tasks.max_concurrent_tasks", 5) }
+
+func taskTimeout() time.Duration {
+	return time.Duration(intSetting("tasks.task_timeout_sec", 120)) * time.Second
+}
+
+func maxAttempts() int { return intSetting("tasks.max_attempts", 3) }
+
+func workStealing() bool {
+	on, ok := settings.Get("tasks.work_stealing").(bool)
+	return on || !ok
+}
+
+func leaseTimeout() time.Duration {
+	return time.Duration(intSetting("tasks.lease_timeout_sec", 300)) * time.Second
+}
+
+func retryBackoff() time.Duration {
+	return time.Duration(intSetting("tasks.retry_backoff_sec", 10)) * time.Second
+}
+
+func intSetting(key string, def int) int {
+	if n, ok := settings.Get(key).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
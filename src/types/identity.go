@@ -0,0 +1,127 @@
+package types
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "atsuko-nexus/src/logger"
+    "atsuko-nexus/src/settings"
+)
+
+// Identity is the node's persistent Ed25519 keypair used to sign its own peer record.
+type Identity struct {
+    Private ed25519.PrivateKey
+    Public  ed25519.PublicKey
+}
+
+// errCorruptKey marks a key file that was read but does not hold a usable key.
+var errCorruptKey = errors.New("corrupt identity key")
+
+var (
+    identity     *Identity
+    identityOnce sync.Once
+)
+
+// LocalIdentity returns the node's keypair, loading it from identity.key_file or generating
+// and persisting a new one on first run. The result is cached for the life of the process.
+// A key file that cannot be read is never overwritten: LocalIdentity returns nil instead. A file
+// that was read but holds no valid key is renamed aside before a new key is generated.
+func LocalIdentity() *Identity {
+    identityOnce.Do(func() {
+        path := identityKeyPath()
+        id, err := loadIdentity(path)
+        if err == nil {
+            identity = id
+            logger.Log("DEBUG", "IDENTITY", "Loaded identity key from "+path)
+            return
+        }
+        switch {
+        case os.IsNotExist(err):
+            // First run
+        case errors.Is(err, errCorruptKey):
+            aside := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+            if rerr := os.Rename(path, aside); rerr != nil {
+                logger.Log("ERROR", "IDENTITY", fmt.Sprintf("Identity key at %s unusable (%v) and could not be moved aside: %v", path, err, rerr))
+                return
+            }
+            logger.Log("ERROR", "IDENTITY", fmt.Sprintf("Identity key at %s unusable (%v); moved it to %s and generating a new one", path, err, aside))
+        default:
+            logger.Log("ERROR", "IDENTITY", fmt.Sprintf("Cannot read identity key at %s: %v", path, err))
+            return
+        }
+
+        id, err = createIdentity(path)
+        if err != nil {
+            logger.Log("ERROR", "IDENTITY", "Failed to create identity key: "+err.Error())
+            return
+        }
+        identity = id
+        logger.Log("INFO", "IDENTITY", "Generated new identity key at "+path)
+    })
+    return identity
+}
+
+// PublicHex returns the public key as a hex string.
+func (id *Identity) PublicHex() string {
+    return hex.EncodeToString(id.Public)
+}
+
+// Sign signs msg with the private key.
+func (id *Identity) Sign(msg []byte) []byte {
+    return ed25519.Sign(id.Private, msg)
+}
+
+// identityKeyPath resolves identity.key_file relative to the executable.
+func identityKeyPath() string {
+    rel := fmt.Sprint(settings.Get("identity.key_file"))
+    if filepath.IsAbs(rel) {
+        return rel
+    }
+    exePath, _ := os.Executable()
+    return filepath.Join(filepath.Dir(exePath), rel)
+}
+
+// loadIdentity reads a hex-encoded private key (seed or full key) from path.
+func loadIdentity(path string) (*Identity, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    privBytes, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", errCorruptKey, err)
+    }
+
+    var priv ed25519.PrivateKey
+    switch len(privBytes) {
+    case ed25519.SeedSize:
+        priv = ed25519.NewKeyFromSeed(privBytes)
+    case ed25519.PrivateKeySize:
+        priv = ed25519.PrivateKey(privBytes)
+    default:
+        return nil, fmt.Errorf("%w: invalid private key length %d", errCorruptKey, len(privBytes))
+    }
+    return &Identity{Private: priv, Public: priv.Public().(ed25519.PublicKey)}, nil
+}
+
+// createIdentity generates a keypair and writes the private key to path with owner-only permissions.
+func createIdentity(path string) (*Identity, error) {
+    privHex, _, err := generateKeyPairHex()
+    if err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(path, []byte(privHex+"\n"), 0600); err != nil {
+        return nil, err
+    }
+    return loadIdentity(path)
+}
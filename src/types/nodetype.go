@@ -1,7 +1,6 @@
 package types
 
 import (
-    "bytes"
     "crypto/ed25519"
     "encoding/hex"
     "fmt"
@@ -10,49 +9,76 @@ import (
     "atsuko-nexus/src/settings"
 )
 
-// expectedAdminPubKeyHex is the reference public key for admin verification.
+// expectedAdminPubKeyHex is the admin public key embedded in every build. It seeds the trust set
+// (see trust.go) and can be rotated away or revoked like any other trusted key.
 const expectedAdminPubKeyHex = "126b187c2410505fe5cba6259de4bd15d1567fd0e6559514f91911e1887a0d56"
 
-// NodeType checks the stored identity.admin_key setting against the expected admin public key.
-// It derives the public key from the given private key (hex), compares it, logs the result, and returns "admin" or "default".
+// NodeType checks the stored identity.admin_key setting against the trust set.
+// It derives the public key from the given private key (hex), looks up its role, logs the result, and returns "admin" or "default".
+// A non-admin node with identity.bot_frontend set reports "bot" when its key is a trusted operator
+// key, which signs the proof peers check before counting it as a bot.
 func NodeType() string {
-    raw := settings.Get("identity.admin_key")
-    keyHex, ok := raw.(string)
+    role := "default"
+    privKey, err := configuredAdminKey()
+    keyRole := ""
+    if err != nil {
+        logger.Log("ERROR", "NODETYPE", err.Error())
+    } else {
+        keyRole = TrustedRole(privKey.Public().(ed25519.PublicKey))
+    }
+    if keyRole == RoleAdmin {
+        role = "admin"
+    }
+    if role == "default" && settings.Get("identity.bot_frontend") == true {
+        if keyRole == RoleOperator {
+            role = "bot"
+        } else {
+            logger.Log("WARNING", "NODETYPE", "identity.bot_frontend needs a trusted operator key in identity.admin_key; running as default")
+        }
+    }
+
+    logger.Log("DEBUG", "NODETYPE", fmt.Sprintf("Determined node role: %s", role))
+    return role
+}
+
+// AdminSigningKey returns the private key from identity.admin_key when it is a trusted admin key.
+func AdminSigningKey() (ed25519.PrivateKey, bool) {
+    privKey, role, ok := SigningKey()
+    return privKey, ok && role == RoleAdmin
+}
+
+// SigningKey returns the private key from identity.admin_key and its trust role (admin or operator),
+// or false when the configured key is missing or not trusted.
+func SigningKey() (ed25519.PrivateKey, string, bool) {
+    privKey, err := configuredAdminKey()
+    if err != nil {
+        return nil, "", false
+    }
+    role := TrustedRole(privKey.Public().(ed25519.PublicKey))
+    if role == "" {
+        return nil, "", false
+    }
+    return privKey, role, true
+}
+
+// configuredAdminKey parses identity.admin_key as a hex Ed25519 seed or full private key.
+func configuredAdminKey() (ed25519.PrivateKey, error) {
+    keyHex, ok := settings.Get("identity.admin_key").(string)
     if !ok {
-        logger.Log("WARN", "NODETYPE", "identity.admin_key not set or invalid type")
-        return "default"
+        return nil, fmt.Errorf("identity.admin_key not set or invalid type")
     }
 
     privBytes, err := hex.DecodeString(keyHex)
     if err != nil {
-        logger.Log("ERROR", "NODETYPE", fmt.Sprintf("failed to decode admin_key hex: %v", err))
-        return "default"
+        return nil, fmt.Errorf("failed to decode admin_key hex: %v", err)
     }
 
-    var pubKey ed25519.PublicKey
     switch len(privBytes) {
     case ed25519.SeedSize:
-        privKey := ed25519.NewKeyFromSeed(privBytes)
-        pubKey = privKey.Public().(ed25519.PublicKey)
+        return ed25519.NewKeyFromSeed(privBytes), nil
     case ed25519.PrivateKeySize:
-        privKey := ed25519.PrivateKey(privBytes)
-        pubKey = privKey.Public().(ed25519.PublicKey)
+        return ed25519.PrivateKey(privBytes), nil
     default:
-        logger.Log("ERROR", "NODETYPE", fmt.Sprintf("invalid private key length: %d", len(privBytes)))
-        return "default"
-    }
-
-    expectedPubBytes, err := hex.DecodeString(expectedAdminPubKeyHex)
-    if err != nil {
-        logger.Log("ERROR", "NODETYPE", fmt.Sprintf("failed to decode expected public key hex: %v", err))
-        return "default"
-    }
-
-    role := "default"
-    if bytes.Equal(pubKey, expectedPubBytes) {
-        role = "admin"
+        return nil, fmt.Errorf("invalid private key length: %d", len(privBytes))
     }
-
-    logger.Log("DEBUG", "NODETYPE", fmt.Sprintf("Determined node role: %s", role))
-    return role
-}
\ No newline at end of file
+}
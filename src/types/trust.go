@@ -0,0 +1,284 @@
+package types
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+
+    "atsuko-nexus/src/db"
+    "atsuko-nexus/src/logger"
+    "atsuko-nexus/src/settings"
+)
+
+// Trust roles. Admins may issue every network command and change the trust set; operators may
+// only issue day-to-day commands such as bans and pausing tasks.
+const (
+    RoleAdmin    = "admin"
+    RoleOperator = "operator"
+)
+
+// trustSetMetaKey holds the persisted network trust state in the database metadata bucket.
+// Keys seeded from the build or settings are never stored there.
+const trustSetMetaKey = "trust_network"
+
+// TrustedKey is one public key in the trust root.
+type TrustedKey struct {
+    Key   string `json:"key"`
+    Role  string `json:"role"`
+    Added string `json:"added,omitempty"`
+}
+
+// TrustSet is the trust granted over the network: the keys added by trust commands and the keys
+// revoked by them, which stay revoked even if the build or settings list them. Version counts the
+// trust commands applied. The keys actually trusted are the roots (the embedded admin key and
+// identity.trusted_keys) plus Keys, minus Revoked; Trust returns that view.
+type TrustSet struct {
+    Version int          `json:"version"`
+    Keys    []TrustedKey `json:"keys"`
+    Revoked []string     `json:"revoked,omitempty"`
+    Updated string       `json:"updated,omitempty"`
+}
+
+var (
+    network   *TrustSet
+    trustOnce sync.Once
+    trustMu   sync.RWMutex
+)
+
+// Trust returns the active trust set: the roots and network-granted keys, minus anything revoked.
+func Trust() TrustSet {
+    return NetworkTrust().Active()
+}
+
+// NetworkTrust returns a copy of the network trust state, loading it from the database on first use.
+func NetworkTrust() TrustSet {
+    trustOnce.Do(loadTrust)
+    trustMu.RLock()
+    defer trustMu.RUnlock()
+    out := *network
+    out.Keys = append([]TrustedKey(nil), network.Keys...)
+    out.Revoked = append([]string(nil), network.Revoked...)
+    return out
+}
+
+// SetTrust persists ts as the network trust state and makes it active.
+func SetTrust(ts TrustSet) error {
+    trustOnce.Do(loadTrust)
+    sort.Slice(ts.Keys, func(i, j int) bool { return ts.Keys[i].Key < ts.Keys[j].Key })
+    sort.Strings(ts.Revoked)
+    data, err := json.Marshal(ts)
+    if err != nil {
+        return err
+    }
+    if err := db.SetMeta(trustSetMetaKey, string(data)); err != nil {
+        return err
+    }
+    trustMu.Lock()
+    changed := ts.Version != network.Version
+    network = &ts
+    trustMu.Unlock()
+    if changed {
+        logger.Log("INFO", "IDENTITY", fmt.Sprintf("Trust set updated to version %d (%d keys trusted)", ts.Version, len(ts.Active().Keys)))
+    }
+    return nil
+}
+
+// TrustRoots returns the keys trusted without any trust command: the embedded admin key and
+// identity.trusted_keys.
+func TrustRoots() []TrustedKey {
+    return append([]TrustedKey{{Key: expectedAdminPubKeyHex, Role: RoleAdmin}}, configuredTrustedKeys()...)
+}
+
+// Active returns ts combined with the roots: every key trusted under ts, minus revoked ones.
+func (ts TrustSet) Active() TrustSet {
+    out := ts
+    out.Keys = nil
+    for _, k := range append(append([]TrustedKey(nil), ts.Keys...), TrustRoots()...) {
+        if !contains(ts.Revoked, k.Key) && !hasKey(out.Keys, k.Key) {
+            out.Keys = append(out.Keys, k)
+        }
+    }
+    out.Revoked = append([]string(nil), ts.Revoked...)
+    return out
+}
+
+// RoleOf returns the role keyHex holds under ts, or "" if it is not trusted.
+func (ts TrustSet) RoleOf(keyHex string) string {
+    for _, k := range ts.Active().Keys {
+        if k.Key == keyHex {
+            return k.Role
+        }
+    }
+    return ""
+}
+
+// TrustedRole returns the role of pub in the trust set, or "" if it is not trusted.
+func TrustedRole(pub []byte) string {
+    return NetworkTrust().RoleOf(hex.EncodeToString(pub))
+}
+
+// AdminKeys returns every public key holding the admin role.
+func AdminKeys() []ed25519.PublicKey {
+    return RoleKeys(RoleAdmin)
+}
+
+// RoleKeys returns every public key holding one of roles.
+func RoleKeys(roles ...string) []ed25519.PublicKey {
+    var out []ed25519.PublicKey
+    for _, k := range Trust().Keys {
+        if !contains(roles, k.Role) {
+            continue
+        }
+        if pub, err := hex.DecodeString(k.Key); err == nil && len(pub) == ed25519.PublicKeySize {
+            out = append(out, ed25519.PublicKey(pub))
+        }
+    }
+    return out
+}
+
+// Grant trusts keyHex with role, or changes the role of a key already trusted. added is recorded
+// as the time the key was granted.
+func (ts *TrustSet) Grant(keyHex, role, added string) error {
+    keyHex = strings.ToLower(keyHex)
+    if err := validKey(keyHex); err != nil {
+        return err
+    }
+    if role != RoleAdmin && role != RoleOperator {
+        return fmt.Errorf("unknown role %q", role)
+    }
+    ts.Revoked = without(ts.Revoked, keyHex)
+    for i := range ts.Keys {
+        if ts.Keys[i].Key == keyHex {
+            ts.Keys[i].Role = role
+            return nil
+        }
+    }
+    ts.Keys = append(ts.Keys, TrustedKey{Key: keyHex, Role: role, Added: added})
+    return nil
+}
+
+// Revoke removes keyHex for good. The last admin key cannot be revoked.
+func (ts *TrustSet) Revoke(keyHex string) error {
+    keyHex = strings.ToLower(keyHex)
+    admins := 0
+    for _, k := range ts.Active().Keys {
+        if k.Key != keyHex && k.Role == RoleAdmin {
+            admins++
+        }
+    }
+    if admins == 0 {
+        return errors.New("refusing to revoke the last admin key")
+    }
+    ts.Keys = withoutKey(ts.Keys, keyHex)
+    if !contains(ts.Revoked, keyHex) {
+        ts.Revoked = append(ts.Revoked, keyHex)
+    }
+    return nil
+}
+
+// Rotate replaces oldHex with newHex, which inherits its role.
+func (ts *TrustSet) Rotate(oldHex, newHex, added string) error {
+    oldHex, newHex = strings.ToLower(oldHex), strings.ToLower(newHex)
+    if err := validKey(newHex); err != nil {
+        return err
+    }
+    role := ts.RoleOf(oldHex)
+    if role == "" {
+        return fmt.Errorf("key %s is not trusted", oldHex)
+    }
+    if err := ts.Grant(newHex, role, added); err != nil {
+        return err
+    }
+    ts.Keys = withoutKey(ts.Keys, oldHex)
+    if !contains(ts.Revoked, oldHex) {
+        ts.Revoked = append(ts.Revoked, oldHex)
+    }
+    return nil
+}
+
+// loadTrust reads the network trust state from the database.
+func loadTrust() {
+    ts := &TrustSet{}
+    if raw := db.GetMeta(trustSetMetaKey); raw != "" {
+        if err := json.Unmarshal([]byte(raw), ts); err != nil {
+            logger.Log("ERROR", "IDENTITY", "Stored trust set unreadable; starting from the roots: "+err.Error())
+            ts = &TrustSet{}
+        }
+    }
+    network = ts
+    logger.Log("DEBUG", "IDENTITY", fmt.Sprintf("Trust set version %d with %d network-granted keys", ts.Version, len(ts.Keys)))
+}
+
+// configuredTrustedKeys parses identity.trusted_keys, a list of {key, role} maps.
+func configuredTrustedKeys() []TrustedKey {
+    raw, _ := settings.Get("identity.trusted_keys").([]interface{})
+    var out []TrustedKey
+    for _, item := range raw {
+        m, ok := item.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        keyHex := strings.ToLower(fmt.Sprint(m["key"]))
+        role := fmt.Sprint(m["role"])
+        if role != RoleAdmin && role != RoleOperator {
+            role = RoleOperator
+        }
+        if err := validKey(keyHex); err != nil {
+            logger.Log("ERROR", "IDENTITY", fmt.Sprintf("Ignoring trusted key %q: %v", keyHex, err))
+            continue
+        }
+        out = append(out, TrustedKey{Key: keyHex, Role: role})
+    }
+    return out
+}
+
+func validKey(keyHex string) error {
+    pub, err := hex.DecodeString(keyHex)
+    if err != nil || len(pub) != ed25519.PublicKeySize {
+        return fmt.Errorf("not a hex Ed25519 public key")
+    }
+    return nil
+}
+
+func hasKey(keys []TrustedKey, keyHex string) bool {
+    for _, k := range keys {
+        if k.Key == keyHex {
+            return true
+        }
+    }
+    return false
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
+
+func withoutKey(keys []TrustedKey, keyHex string) []TrustedKey {
+    var out []TrustedKey
+    for _, k := range keys {
+        if k.Key != keyHex {
+            out = append(out, k)
+        }
+    }
+    return out
+}
+
+func without(list []string, s string) []string {
+    var out []string
+    for _, v := range list {
+        if v != s {
+            out = append(out, v)
+        }
+    }
+    return out
+}
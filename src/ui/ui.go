@@ -1,8 +1,9 @@
 // Package ui provides the interactive terminal user interface (TUI) for Atsuko Nexus.
-// It displays live system metrics, logs, and general status information using the Bubble Tea framework.
+// It displays live logs (including heartbeat metrics) and general status information using the Bubble Tea framework.
 package ui
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -11,22 +12,16 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	"github.com/charmbracelet/lipgloss"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/net"
-
 	"atsuko-nexus/src/logger"
 	"atsuko-nexus/src/settings"
 	"atsuko-nexus/src/version"
 	"atsuko-nexus/src/p2p"
+	"atsuko-nexus/src/tasks"
 )
 
 var (
-	startTime     = time.Now() // Used to calculate uptime
-	nodeID        string // The Node ID shown in the UI
-	lastBytesSent uint64 // Used to track network upload delta
-	lastBytesRecv uint64 // Used to track network download delta
-	lastNetTime   time.Time // Last time network was sampled
+	startTime = time.Now() // Used to calculate uptime
+	nodeID    string       // The Node ID shown in the UI
 )
 
 // model defines the Bubble Tea view model with viewport support.
@@ -35,28 +30,13 @@ type model struct {
 	ready    bool
 }
 
-type tickMsg struct{}      // Message used to trigger log refresh
-type heartbeatMsg struct{} // Message used to trigger system metric heartbeat
+type tickMsg struct{} // Message used to trigger log refresh
 
 // getUptime returns a human-readable duration string since app launch.
 func getUptime() string {
 	return time.Since(startTime).Round(time.Second).String()
 }
 
-// formatBytes converts a byte count into a human-readable string with units.
-func formatBytes(b uint64) string {
-	const unit = 1024
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
-	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
-}
-
 // tick returns a Bubble Tea command that sends a tickMsg at the configured interval.
 func tick() tea.Cmd {
 	refreshSec := settings.Get("ui.panel_refresh_time")
@@ -73,28 +53,12 @@ func tick() tea.Cmd {
 	})
 }
 
-// heartbeatTick returns a Bubble Tea command that sends a heartbeatMsg on interval.
-func heartbeatTick() tea.Cmd {
-	interval := settings.Get("metrics.heartbeat_interval")
-	intervalDur := 120 * time.Second
-
-	if sec, ok := interval.(int); ok && sec > 0 {
-		intervalDur = time.Duration(sec) * time.Second
-	} else if fsec, ok := interval.(float64); ok && fsec > 0 {
-		intervalDur = time.Duration(fsec) * time.Second
-	}
-
-	return tea.Tick(intervalDur, func(t time.Time) tea.Msg {
-		return heartbeatMsg{}
-	})
-}
-
-// Init sets up the Bubble Tea program to run both the tick and heartbeat loops.
+// Init sets up the Bubble Tea program to run the log refresh loop.
 func (m model) Init() tea.Cmd {
-	return tea.Batch(tick(), heartbeatTick())
+	return tick()
 }
 
-// Update handles user interaction, window size changes, tick/heartbeat messages, and viewport updates.
+// Update handles user interaction, window size changes, tick messages, and viewport updates.
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
@@ -119,68 +83,6 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tick()
 
-	case heartbeatMsg:
-		logMsg := "Node still alive"
-		logger.Log("DEBUG", "heartbeat", "heartbeatMsg received, collecting metrics...")
-
-		if settings.Get("metrics.enable_metrics") == true {
-			parts := []string{}
-
-			if settings.Get("metrics.cpu_monitoring") == true {
-				if usage, _ := cpu.Percent(0, false); len(usage) > 0 {
-					logger.Log("DEBUG", "heartbeat", fmt.Sprintf("CPU usage: %.1f%%", usage[0]))
-					parts = append(parts, fmt.Sprintf("CPU: %.1f%%", usage[0]))
-				}
-			}
-
-			if settings.Get("metrics.ram_monitoring") == true {
-				if vmStat, _ := mem.VirtualMemory(); vmStat != nil {
-					logger.Log("DEBUG", "heartbeat", fmt.Sprintf("RAM usage: %.1f%% (%s/%s)",
-						vmStat.UsedPercent,
-						formatBytes(vmStat.Used),
-						formatBytes(vmStat.Total)))
-					parts = append(parts, fmt.Sprintf("RAM: %.1f%% (%s/%s)",
-						vmStat.UsedPercent,
-						formatBytes(vmStat.Used),
-						formatBytes(vmStat.Total),
-					))
-				}
-			}
-
-			if settings.Get("metrics.net_traffic_monitoring") == true {
-				if ioStat, _ := net.IOCounters(false); len(ioStat) > 0 {
-					now := time.Now()
-					elapsed := now.Sub(lastNetTime).Seconds()
-
-					deltaSent := float64(ioStat[0].BytesSent - lastBytesSent)
-					deltaRecv := float64(ioStat[0].BytesRecv - lastBytesRecv)
-
-					upRate := deltaSent / elapsed
-					downRate := deltaRecv / elapsed
-
-					logger.Log("DEBUG", "heartbeat", fmt.Sprintf("Net ↑ %s/s ↓ %s/s",
-						formatBytes(uint64(upRate)),
-						formatBytes(uint64(downRate))))
-
-					lastBytesSent = ioStat[0].BytesSent
-					lastBytesRecv = ioStat[0].BytesRecv
-					lastNetTime = now
-
-					parts = append(parts, fmt.Sprintf("Net: ↑ %s/s ↓ %s/s",
-						formatBytes(uint64(upRate)),
-						formatBytes(uint64(downRate)),
-					))
-				}
-			}
-
-			if len(parts) > 0 {
-				logMsg = strings.Join(parts, " | ")
-			}
-		}
-
-		logger.Log("INFO", "heartbeat", logMsg)
-		return m, heartbeatTick()
-
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q", "ctrl+c":
@@ -199,9 +101,13 @@ func (m model) View() string {
 		Bold(true).
 		Render("💠 Atsuko Nexus 💠")
 
+	limits := p2p.GetRateLimitStats()
+	queued := tasks.Default().Stats()
+	skew := p2p.GetClockSkewStats()
 	status := lipgloss.NewStyle().
 		Faint(true).
-		Render(fmt.Sprintf("Version: %s | Uptime: %s | Node ID: %s | Peers: %d", version.Current, getUptime(), nodeID, p2p.CountActivePeers()))
+		Render(fmt.Sprintf("Version: %s | Uptime: %s | Node ID: %s | Peers: %d | Rate-limited: %d (%d cooling) | Tasks: %d queued, %d running | Max clock skew: %s",
+			version.Current, getUptime(), nodeID, p2p.CountActivePeers(), limits.Limited, limits.Cooling, queued.Queued, queued.Running, skew.Worst.Round(time.Millisecond)))
 
 	help := lipgloss.NewStyle().
 		Italic(true).
@@ -211,22 +117,14 @@ func (m model) View() string {
 	return header + "\n" + status + "\n" + help + "\n" + m.viewport.View()
 }
 
-// Start launches the user interface, initializing network counters and running the TUI.
-func Start(id string) {
+// Start launches the user interface and runs the TUI until the user quits or ctx is cancelled.
+func Start(ctx context.Context, id string) {
 	logger.Log("DEBUG", "UI", "UI Start() called.")
 	nodeID = id
-	lastNetTime = time.Now()
-
-	logger.Log("DEBUG", "UI", "Initializing network counters...")
-	if counters, _ := net.IOCounters(false); len(counters) > 0 {
-		lastBytesSent = counters[0].BytesSent
-		lastBytesRecv = counters[0].BytesRecv
-		logger.Log("DEBUG", "UI", fmt.Sprintf("Initial Bytes Sent: %d, Bytes Recv: %d", lastBytesSent, lastBytesRecv))
-	}
 
 	logger.Log("INFO", "UI", "Launching TUI...")
-	p := tea.NewProgram(model{}, tea.WithAltScreen(), tea.WithMouseCellMotion())
-	if _, err := p.Run(); err != nil {
+	p := tea.NewProgram(model{}, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithContext(ctx))
+	if _, err := p.Run(); err != nil && ctx.Err() == nil {
 		logger.Log("ERROR", "UI", fmt.Sprintf("TUI crashed: %v", err))
 		panic(err)
 	}
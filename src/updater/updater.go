@@ -12,7 +12,6 @@ import (
 	"runtime"
 	"sort"
 	"strings"
-	"time"
 
 	"atsuko-nexus/src/logger"
 	"atsuko-nexus/src/version"
@@ -34,7 +33,9 @@ type GitHubRelease struct {
 	} `json:"assets"`
 }
 
-func RunUpdater() {
+// RunUpdater checks for a newer release in the current channel and installs it.
+// It returns true when an update was applied and the process should shut down so it can be restarted.
+func RunUpdater() bool {
 	logger.Log("INFO", "updater", "Checking for updates...")
 
 	currentVersion := version.Get()
@@ -46,13 +47,13 @@ func RunUpdater() {
 	releases, err := fetchAllReleases()
 	if err != nil {
 		logger.Log("ERROR", "updater", "Failed to fetch releases: "+err.Error())
-		return
+		return false
 	}
 
 	filtered := filterReleasesByChannel(releases, channel)
 	if len(filtered) == 0 {
 		logger.Log("ERROR", "updater", "No releases available in current channel")
-		return
+		return false
 	}
 
 	latest := filtered[0]
@@ -63,14 +64,14 @@ func RunUpdater() {
 
 	if err1 == nil && err2 == nil && !latestVer.GreaterThan(currVer) {
 		logger.Log("INFO", "updater", "Already up to date: "+currentVersion)
-		return
+		return false
 	}
 
 	targetName := buildTargetName() + ".zip"
 	assetURL := findAssetURL(&latest, targetName)
 	if assetURL == "" {
 		logger.Log("ERROR", "updater", "No matching asset found: "+targetName)
-		return
+		return false
 	}
 
 	logger.Log("INFO", "updater", fmt.Sprintf("Updating from %s to %s", currentVersion, latest.TagName))
@@ -78,25 +79,24 @@ func RunUpdater() {
 	tmpZip := "atsuko_update.zip"
 	if err := downloadFile(tmpZip, assetURL); err != nil {
 		logger.Log("ERROR", "updater", "Download failed: "+err.Error())
-		return
+		return false
 	}
 
 	tmpBin := "atsuko_tmp"
 	if err := extractBinaryFromZip(tmpZip, tmpBin); err != nil {
 		logger.Log("ERROR", "updater", "Unzip failed: "+err.Error())
-		return
+		return false
 	}
 	_ = os.Remove(tmpZip)
 
 	if err := applyUpdate(tmpBin); err != nil {
 		logger.Log("ERROR", "updater", "Failed to apply update: "+err.Error())
 		fmt.Println("Failed to apply update: " + err.Error())
-		return
+		return false
 	}
 
 	logger.Log("INFO", "updater", "Update applied successfully. Please restart the application manually.")
-	time.Sleep(3 * time.Second)
-	os.Exit(0)
+	return true
 }
 
 func detectChannel(version string) string {